@@ -72,9 +72,51 @@ def exists_github_repo(project_name, github_projects):
     return False
 
 
+def uses_lfs():
+    # a bare clone has no working tree, so grep .gitattributes on every branch
+    refs = subprocess.run(["git", "for-each-ref", "--format=%(refname)", "refs/heads"], capture_output=True, text=True)
+    if refs.returncode != 0:
+        raise Exception(f"Listing refs failed with: {refs.stderr}")
+    if not refs.stdout.split():
+        return False
+    result = subprocess.run(
+        ["git", "grep", "-l", "filter=lfs", *refs.stdout.split(), "--", ".gitattributes", "*/.gitattributes"],
+        capture_output=True,
+        text=True,
+    )
+    return result.stdout != ""
+
+
+def lfs_object_stats():
+    count, size = 0, 0
+    for root, _, files in os.walk(os.path.join("lfs", "objects")):
+        for name in files:
+            count += 1
+            size += os.path.getsize(os.path.join(root, name))
+    return count, size
+
+
+def push_lfs_to_github(project_name, github_url):
+    if not uses_lfs():
+        return
+    result = subprocess.run(["git", "lfs", "version"], capture_output=True, text=True)
+    if result.returncode != 0:
+        raise Exception(f"{project_name} uses Git LFS but git-lfs is not installed")
+    result = subprocess.run(["git", "lfs", "fetch", "--all", "origin"], capture_output=True, text=True)
+    if result.returncode != 0:
+        raise Exception(f"Git LFS fetch failed with: {result.stderr}")
+    count, size = lfs_object_stats()
+    logging.info(f"LFS objects for {project_name}: {count}, {size} bytes")
+    result = subprocess.run(["git", "lfs", "push", "--all", github_url], capture_output=True, text=True)
+    if result.returncode != 0:
+        raise Exception(f"Git LFS push failed with: {result.stderr}")
+    logging.info(f"Pushed LFS objects to GitHub: {project_name} {github_url}")
+
+
 def push_to_github(project_name):
     os.chdir(os.path.join(constants.REPOS_ROOT, f"{project_name}.git"))
     github_url = f"https://github.com/uncw-library/{project_name}"
+    push_lfs_to_github(project_name, github_url)
     result = subprocess.run(["git", "push", "--mirror", github_url], capture_output=True, text=True)
     if result.returncode != 0:
         raise Exception(f"Git push failed with: {result.stderr}")
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bareSuffix names the mirror clone -bare works in, beside where the project's normal clone would be.
+const bareSuffix = ".bare.git"
+
+// treeEntry is one line of git ls-tree -r -l.
+type treeEntry struct {
+	mode string
+	kind string
+	sha  string
+	size int64
+	name string
+}
+
+// bareUpdate is a branch rewritten in the mirror, waiting for the push.
+type bareUpdate struct {
+	branch Branch
+	edits  []*fileEdit
+	commit string
+	// old is the tip the rewrite built on; expected is the lease on the push remote's branch, "" meaning it must not exist
+	old      string
+	expected string
+}
+
+// tip is what the branch should point at on the push remote.
+func (u *bareUpdate) tip() string {
+	if u.commit != "" {
+		return u.commit
+	}
+	return u.old
+}
+
+// bareFolder rewrites the project's branches in a bare mirror clone, building each commit straight from the
+// object database without checking anything out, and pushes every updated branch in one git push.
+// The mirror is fetched again on each run, which resets any branch a failed push left behind.
+func bareFolder(logger *log.Logger, folder string, project Project, opts *options) error {
+	logger.Printf("Starting\tbare rewrite of %s", project.Name)
+	mirror := folder + bareSuffix
+	var err error
+	if nonEmptyDir(mirror) {
+		_, err = runCommand(logger, mirror, "git", "fetch", "--prune", "origin")
+	} else {
+		_, err = runCommand(logger, ".", "git", "clone", "--mirror", project.URL, mirror)
+	}
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error mirroring repository %s", project.URL)
+	}
+	push := opts.pushRemote != "" && !opts.dryRun
+	if push && opts.pushRemote != "origin" {
+		err = setupRemote(logger, mirror, project, opts.pushRemote, opts)
+		if err != nil {
+			return err
+		}
+		_, err = runCommand(logger, mirror, "git", "fetch", "--prune", opts.pushRemote)
+		if err != nil {
+			logger.Printf("Error\t%v", err)
+			return fmt.Errorf("error fetching %s in folder %s", opts.pushRemote, mirror)
+		}
+	}
+	project.LFS, err = usesLFS(logger, mirror)
+	if err != nil {
+		return err
+	}
+	if project.LFS && opts.pushRemote == "github" {
+		// the rewrite never touches LFS pointers, but their objects would have to be uploaded from a working clone
+		return fmt.Errorf("%s uses Git LFS, which -bare can't push to github; run it without -bare", project.Name)
+	}
+	if project.LFS {
+		err = fetchLFS(logger, mirror)
+		if err != nil {
+			return err
+		}
+		count, size, err := lfsObjectStats(logger, mirror)
+		if err != nil {
+			return err
+		}
+		logger.Printf("Info\tLFS objects: %d, %d bytes", count, size)
+		opts.report.recordLFS(project, count, size)
+	}
+	if opts.scanSecrets {
+		findings, err := scanHistory(logger, mirror, opts.secretAllow)
+		if err != nil {
+			return err
+		}
+		opts.secrets.record(project.Name, findings)
+		opts.report.recordSecrets(project, len(findings))
+		project.SecretFindings = len(findings)
+		project.SecretFingerprints = fingerprints(findings)
+		logger.Printf("Info\t%d secret findings in the history of %s", len(findings), project.Name)
+	}
+
+	updates := []*bareUpdate{}
+	for _, branch := range project.Branches {
+		if ok, reason := opts.branches.allows(branch, time.Now()); !ok {
+			logger.Printf("Info\tSkipping branch %s: %s", branch.Name, reason)
+			opts.report.recordBranch(project, branch.Name, journalSkipped, reason, nil, "")
+			continue
+		}
+		if run, reason := opts.journal.shouldRun(project, branch.Name, opts.retryFailed); !run {
+			logger.Printf("Info\tSkipping branch %s: %s", branch.Name, reason)
+			opts.report.recordBranch(project, branch.Name, journalSkipped, reason, nil, "")
+			continue
+		}
+		update, err := bareBranch(logger, mirror, &project, branch, opts)
+		var skip *skipError
+		if errors.As(err, &skip) {
+			logger.Printf("Info\tSkipped branch %s: %s", branch.Name, skip.reason)
+			opts.journal.record(project, branch.Name, skip.state(), skip.reason)
+			opts.report.recordBranch(project, branch.Name, skip.state(), skip.reason, update.edits, update.commit)
+			continue
+		}
+		if err != nil {
+			opts.journal.record(project, branch.Name, journalFailed, err.Error())
+			opts.report.recordBranch(project, branch.Name, journalFailed, err.Error(), update.edits, update.commit)
+			return err
+		}
+		if !push {
+			opts.journal.record(project, branch.Name, journalCompleted, "")
+			opts.report.recordBranch(project, branch.Name, journalCompleted, "", update.edits, update.commit)
+			continue
+		}
+		updates = append(updates, update)
+	}
+
+	// checked once every branch is rewritten, so a secret in any branch's rewrite blocks all of them
+	allowed := []*bareUpdate{}
+	for _, update := range updates {
+		err := pushAllowed(logger, project, update.branch, opts)
+		var skip *skipError
+		if errors.As(err, &skip) {
+			logger.Printf("Info\tSkipped branch %s: %s", update.branch.Name, skip.reason)
+			opts.journal.record(project, update.branch.Name, skip.state(), skip.reason)
+			opts.report.recordBranch(project, update.branch.Name, skip.state(), skip.reason, update.edits, update.commit)
+			continue
+		}
+		allowed = append(allowed, update)
+	}
+	updates = allowed
+	if len(updates) == 0 {
+		return nil
+	}
+
+	failed := pushUpdates(logger, mirror, project, updates, opts)
+	for _, update := range updates {
+		if reason, ok := failed[update.branch.Name]; ok {
+			opts.journal.record(project, update.branch.Name, journalFailed, reason)
+			opts.report.recordBranch(project, update.branch.Name, journalFailed, reason, update.edits, update.commit)
+			continue
+		}
+		opts.journal.record(project, update.branch.Name, journalCompleted, "")
+		opts.report.recordBranch(project, update.branch.Name, journalCompleted, "", update.edits, update.commit)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error pushing %d of %d branches to %s", len(failed), len(updates), opts.pushRemote)
+	}
+	return nil
+}
+
+// bareBranch applies the rules to the files of one branch in the mirror and, unless this is a dry run,
+// commits the result on top of the branch and moves the branch to it. The update is never nil.
+func bareBranch(logger *log.Logger, mirror string, project *Project, branch Branch, opts *options) (*bareUpdate, error) {
+	logger.Printf("Starting branch\t%v", branch.Name)
+	update := &bareUpdate{branch: branch, edits: []*fileEdit{}}
+	ref := "refs/heads/" + branch.Name
+	old, err := runCommand(logger, mirror, "git", "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return update, fmt.Errorf("error reading branch %s in folder %s", branch.Name, mirror)
+	}
+	update.old = old
+	update.expected = old
+	if opts.pushRemote != "" && opts.pushRemote != "origin" && !opts.dryRun {
+		// the remote-tracking ref is missing when the branch isn't on the remote yet
+		update.expected, _ = runCommand(logger, mirror, "git", "rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/remotes/%s/%s", opts.pushRemote, branch.Name))
+	}
+
+	entries, err := lsTree(logger, mirror, old)
+	if err != nil {
+		return update, err
+	}
+	byName := map[string]treeEntry{}
+	// contents holds every blob the rewrite changes, written in order into the new tree
+	contents := map[string][]byte{}
+	order := []string{}
+	write := func(name string, data []byte) {
+		if _, ok := contents[name]; !ok {
+			order = append(order, name)
+		}
+		contents[name] = data
+	}
+	for _, entry := range entries {
+		byName[entry.name] = entry
+		// symlinks and submodule commits are no files to edit, as in a worktree
+		if entry.kind != "blob" || entry.mode == "120000" || excludedPath(entry.name, opts.excludes) {
+			continue
+		}
+		fileRules := []rule{}
+		for _, r := range opts.rules {
+			if r.targets(entry.name) {
+				fileRules = append(fileRules, r)
+			}
+		}
+		if len(fileRules) == 0 {
+			continue
+		}
+		if entry.size > opts.maxFileSize {
+			reason := fmt.Sprintf("larger than %d bytes", opts.maxFileSize)
+			logger.Printf("Info\tSkipping file %s: %s", entry.name, reason)
+			opts.report.recordSkippedFile(*project, branch.Name, entry.name, reason)
+			continue
+		}
+		data, err := readBlob(mirror, entry.sha)
+		if err != nil {
+			return update, err
+		}
+		logger.Printf("Starting\trewrite of %s (%s) with %d rules", entry.name, entry.sha, len(fileRules))
+		edit, out, err := rewriteContent(logger, entry.name, data, fileRules)
+		var skip *skipError
+		if errors.As(err, &skip) {
+			logger.Printf("Info\tSkipping file %s: %s", entry.name, skip.reason)
+			opts.report.recordSkippedFile(*project, branch.Name, entry.name, skip.reason)
+			continue
+		}
+		if err != nil {
+			return update, fmt.Errorf("error editing file %s on %s, %v", entry.name, branch.Name, err)
+		}
+		if edit != nil {
+			update.edits = append(update.edits, edit)
+			write(entry.name, out)
+		}
+	}
+
+	// current is a file's content after the edits so far, nil when the branch doesn't have it
+	current := func(name string) ([]byte, error) {
+		if data, ok := contents[name]; ok {
+			return data, nil
+		}
+		entry, ok := byName[name]
+		if !ok || entry.kind != "blob" {
+			return nil, nil
+		}
+		return readBlob(mirror, entry.sha)
+	}
+	gitmodules, err := current(".gitmodules")
+	if err != nil {
+		return update, err
+	}
+	if gitmodules != nil {
+		edit, missing := rewriteGitmodules(*project, opts.inventory, string(gitmodules))
+		if edit != nil {
+			update.edits = append(update.edits, edit)
+			write(edit.name, []byte(edit.after))
+		}
+		for _, m := range missing {
+			logger.Printf("Info\tSubmodule %s points at %s, which is not in the project inventory", m.Name, m.URL)
+		}
+		opts.report.recordMissingSubmodules(*project, branch.Name, missing)
+	}
+
+	if opts.translateCI {
+		source, err := current(gitlabCIPath)
+		if err != nil {
+			return update, err
+		}
+		existing, err := current(ciWorkflowPath)
+		if err != nil {
+			return update, err
+		}
+		if source == nil {
+			logger.Printf("Info\tNo %s to translate", gitlabCIPath)
+		} else {
+			edit, err := ciWorkflowEdit(logger, source, existing)
+			if err != nil {
+				return update, err
+			}
+			if edit != nil {
+				update.edits = append(update.edits, edit)
+				write(edit.name, []byte(edit.after))
+			}
+		}
+	}
+
+	found, err := checkRewrites(logger, *project, branch, update.edits, opts)
+	if err != nil {
+		return update, err
+	}
+	project.SecretFindings += found
+
+	if opts.dryRun {
+		opts.diffs.record(project.Name, branch.Name, update.edits)
+		return update, nil
+	}
+	if len(order) == 0 {
+		logger.Printf("Info\tNothing to commit on %s", branch.Name)
+	} else {
+		update.commit, err = commitEdits(logger, mirror, *project, branch, update, order, contents, byName, opts)
+		if err != nil {
+			return update, err
+		}
+	}
+	return update, nil
+}
+
+// commitEdits writes the changed contents as blobs, commits them on the branch's old tip and moves the branch there.
+func commitEdits(logger *log.Logger, mirror string, project Project, branch Branch, update *bareUpdate, order []string, contents map[string][]byte, byName map[string]treeEntry, opts *options) (string, error) {
+
+	index := []string{}
+	for _, name := range order {
+		sha, err := gitWithInput(logger, mirror, nil, contents[name], "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", fmt.Errorf("error writing %s for %s in folder %s", name, branch.Name, mirror)
+		}
+		mode := "100644"
+		if entry, ok := byName[name]; ok {
+			mode = entry.mode
+		}
+		index = append(index, fmt.Sprintf("%s %s\t%s\x00", mode, sha, name))
+	}
+	message, err := commitMessage(project, branch, update.edits, opts)
+	if err != nil {
+		return "", err
+	}
+	commit, err := commitTree(logger, mirror, update.old, strings.Join(index, ""), message, opts)
+	if err != nil {
+		return "", err
+	}
+	_, err = runCommand(logger, mirror, "git", "update-ref", "-m", "repoSed "+opts.runID, "refs/heads/"+branch.Name, commit, update.old)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return "", fmt.Errorf("error moving %s to %s in folder %s", branch.Name, commit, mirror)
+	}
+	logger.Printf("Info\tCommitted %s on %s", commit, branch.Name)
+	return commit, nil
+}
+
+// pushAllowed returns a *skipError when the branch must not be pushed: it is protected, or the project has secret findings.
+func pushAllowed(logger *log.Logger, project Project, branch Branch, opts *options) error {
+	if branch.Protected && !opts.allowProtected {
+		logger.Printf("Info\tNot pushing protected branch %s", branch.Name)
+		opts.pushes.record(project, branch.Name, opts.pushRemote, "", "refused: protected branch")
+		return &skipError{reason: "protected branch, not pushed", blocked: true}
+	}
+	return blockPushForSecrets(logger, project, branch, opts)
+}
+
+// lsTree lists every file and submodule in commit's tree.
+func lsTree(logger *log.Logger, mirror string, commit string) ([]treeEntry, error) {
+	out, err := runCommand(logger, mirror, "git", "ls-tree", "-r", "-l", "-z", "--full-tree", commit)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return nil, fmt.Errorf("error listing the tree of %s in folder %s", commit, mirror)
+	}
+	entries := []treeEntry{}
+	for _, record := range strings.Split(out, "\x00") {
+		// <mode> <type> <object> <size>\t<path>, with a size of - for submodules
+		meta, name, ok := strings.Cut(record, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, treeEntry{mode: fields[0], kind: fields[1], sha: fields[2], size: size, name: name})
+	}
+	return entries, nil
+}
+
+// readBlob returns a blob's content byte for byte.
+func readBlob(mirror string, sha string) ([]byte, error) {
+	cmd := exec.Command("git", "cat-file", "blob", sha)
+	cmd.Dir = mirror
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading blob %s in folder %s, %v", sha, mirror, err)
+	}
+	return out, nil
+}
+
+// gitWithInput runs git with input on stdin and env added to the environment, returning its trimmed output.
+func gitWithInput(logger *log.Logger, folder string, env []string, input []byte, args ...string) (string, error) {
+	logger.Printf("Running\tfolder: %v, command: git %v", folder, args)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = folder
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		logger.Printf("Error\t%v: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(string(out)), err
+}
+
+// commitTree builds a tree from parent's with the -z --index-info lines in index applied, in a throwaway index file,
+// and commits it on parent with the configured identity and signing. It returns the new commit.
+func commitTree(logger *log.Logger, mirror string, parent string, index string, message string, opts *options) (string, error) {
+	indexFile, err := filepath.Abs(filepath.Join(mirror, "repoSed-index"))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(indexFile)
+	env := []string{"GIT_INDEX_FILE=" + indexFile}
+	_, err = gitWithInput(logger, mirror, env, nil, "read-tree", parent)
+	if err != nil {
+		return "", fmt.Errorf("error reading the tree of %s in folder %s", parent, mirror)
+	}
+	_, err = gitWithInput(logger, mirror, env, []byte(index), "update-index", "-z", "--index-info")
+	if err != nil {
+		return "", fmt.Errorf("error updating the index for %s in folder %s", parent, mirror)
+	}
+	tree, err := gitWithInput(logger, mirror, env, nil, "write-tree")
+	if err != nil {
+		return "", fmt.Errorf("error writing the tree for %s in folder %s", parent, mirror)
+	}
+
+	args := append(gitIdentityArgs(opts), "commit-tree", tree, "-p", parent, "-F", "-")
+	if opts.sign != "" {
+		args = append(args, "-S")
+	}
+	commit, err := gitWithInput(logger, mirror, nil, []byte(message), args...)
+	if err != nil {
+		return "", fmt.Errorf("error committing tree %s on %s in folder %s", tree, parent, mirror)
+	}
+	return commit, nil
+}
+
+// pushUpdates pushes every updated branch to -push in one git push, each with a lease on the remote branch
+// so a branch that moved since the fetch is rejected. It returns why each branch that didn't land failed.
+func pushUpdates(logger *log.Logger, mirror string, project Project, updates []*bareUpdate, opts *options) map[string]string {
+	// the mirror's origin pushes every ref when named, so push to the URL with explicit refspecs
+	dest := destinationURL(project, opts.pushRemote, opts)
+	args := []string{"push", "--porcelain", dest}
+	refspecs := []string{}
+	for _, update := range updates {
+		if update.expected == update.tip() {
+			logger.Printf("Info\tRemote %s already has %s at %s", opts.pushRemote, update.branch.Name, update.expected)
+			opts.pushes.record(project, update.branch.Name, opts.pushRemote, "", "up to date")
+			continue
+		}
+		ref := "refs/heads/" + update.branch.Name
+		args = append(args, fmt.Sprintf("--force-with-lease=%s:%s", ref, update.expected))
+		refspecs = append(refspecs, fmt.Sprintf("%s:%s", update.tip(), ref))
+	}
+	failed := map[string]string{}
+	if len(refspecs) == 0 {
+		return failed
+	}
+
+	// git push exits 1 when any ref is rejected, but --porcelain still reports every ref on stdout
+	out, err := runCommand(logger, mirror, "git", append(args, refspecs...)...)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+	}
+	statuses := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		// <flag>\t<from>:<to>\t<summary>
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		_, to, _ := strings.Cut(fields[1], ":")
+		statuses[strings.TrimPrefix(to, "refs/heads/")] = fields[0] + "\t" + fields[2]
+	}
+	for _, update := range updates {
+		if update.expected == update.tip() {
+			continue
+		}
+		status, ok := statuses[update.branch.Name]
+		flag, summary, _ := strings.Cut(status, "\t")
+		switch {
+		case !ok:
+			failed[update.branch.Name] = fmt.Sprintf("error pushing branch %s to %s", update.branch.Name, opts.pushRemote)
+		case flag == "!":
+			failed[update.branch.Name] = fmt.Sprintf("error pushing branch %s to %s: %s", update.branch.Name, opts.pushRemote, summary)
+		case flag == "=":
+			opts.pushes.record(project, update.branch.Name, opts.pushRemote, "", "up to date")
+			continue
+		default:
+			logger.Printf("Info\tPushed %s to %s at %s", update.branch.Name, opts.pushRemote, update.tip())
+			opts.pushes.record(project, update.branch.Name, opts.pushRemote, update.tip(), "pushed")
+			continue
+		}
+		logger.Printf("Error\t%s", failed[update.branch.Name])
+		opts.pushes.record(project, update.branch.Name, opts.pushRemote, "", "failed")
+	}
+	return failed
+}
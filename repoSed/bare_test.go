@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every branch goes out in one push, and a branch whose lease no longer holds is rejected without stopping the rest.
+func TestPushUpdatesLeasesEachBranchInOnePush(t *testing.T) {
+	f := newFakeGitLab(t)
+	catalog, _ := catalogFixture(f)
+	opts := testOptions(t, f, "go")
+	opts.pushRemote = "origin"
+	tips := branchTips(t, catalog.URL)
+	mirror := filepath.Join(t.TempDir(), "catalog"+bareSuffix)
+	gitRun(t, ".", "clone", "-q", "--mirror", catalog.URL, mirror)
+	rewrite := func(parent string) string {
+		return gitRun(t, mirror, "commit-tree", parent+"^{tree}", "-p", parent, "-m", "Rewrite")
+	}
+
+	// the remote counts the pushes it receives
+	pushCount := filepath.Join(t.TempDir(), "pushes")
+	hook := "#!/bin/sh\necho push >> " + pushCount + "\n"
+	if err := os.WriteFile(filepath.Join(catalog.URL, "hooks", "pre-receive"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mainCommit, featureCommit, newCommit := rewrite(tips["main"]), rewrite(tips["feature"]), rewrite(tips["main"])
+	updates := []*bareUpdate{
+		{branch: Branch{Name: "main"}, commit: mainCommit, old: tips["main"], expected: tips["main"]},
+		// feature moved on the remote since the lease was taken
+		{branch: Branch{Name: "feature"}, commit: featureCommit, old: tips["feature"], expected: tips["main"]},
+		{branch: Branch{Name: "new"}, commit: newCommit, old: tips["main"], expected: ""},
+		{branch: Branch{Name: "unchanged"}, old: tips["main"], expected: tips["main"]},
+	}
+	failed := pushUpdates(log.New(io.Discard, "", 0), mirror, catalog, updates, opts)
+
+	if len(failed) != 1 || !strings.Contains(failed["feature"], "stale info") {
+		t.Errorf("failed %v, want only feature's stale lease", failed)
+	}
+	after := branchTips(t, catalog.URL)
+	if after["main"] != mainCommit || after["new"] != newCommit || after["feature"] != tips["feature"] {
+		t.Errorf("remote tips %v", after)
+	}
+	if data, err := os.ReadFile(pushCount); err != nil || string(data) != "push\n" {
+		t.Errorf("remote received %q pushes, want one: %v", data, err)
+	}
+	statuses := map[string]string{}
+	for _, r := range opts.pushes.results {
+		statuses[r.branch] = r.status
+	}
+	want := map[string]string{"main": "pushed", "feature": "failed", "new": "pushed", "unchanged": "up to date"}
+	for branch, status := range want {
+		if statuses[branch] != status {
+			t.Errorf("%s: push status %q, want %q", branch, statuses[branch], status)
+		}
+	}
+}
+
+func TestDoTheWorkBareRefusesLFSToGithub(t *testing.T) {
+	f := newFakeGitLab(t)
+	lfsFixture(t)
+	catalog := f.addProject("catalog", []string{"main"}, map[string]map[string]string{
+		"main": {".gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n", "logo.png": lfsPointerPrefix + "\n", "README.md": readmeBefore},
+	})
+	before := branchTips(t, catalog.URL)
+	github := t.TempDir()
+	gitRun(t, github, "init", "-q", "--bare", "catalog.git")
+	opts := testOptions(t, f, "go")
+	opts.pushRemote = "github"
+	opts.githubRemote = github
+	opts.bare = true
+
+	_, erroreds := doTheWork(opts)
+	if len(erroreds) != 1 {
+		t.Fatalf("erroreds %v, want the LFS project", erroreds)
+	}
+	want := "catalog uses Git LFS, which -bare can't push to github; run it without -bare"
+	if pr := opts.report.Projects[0]; pr.Status != "error" || pr.Error != want {
+		t.Errorf("report project %+v, want the error %q", pr, want)
+	}
+	if pushed := branchTips(t, filepath.Join(github, "catalog.git")); len(pushed) != 0 {
+		t.Errorf("github remote has %v", pushed)
+	}
+	if after := branchTips(t, catalog.URL); after["main"] != before["main"] {
+		t.Errorf("GitLab's main moved")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// rulesTrailer lists the rules that changed files in a rewrite commit.
+const rulesTrailer = "Migration-Rules"
+
+// defaultCommitTemplate keeps the subject repoSed has always used, so older tooling still recognizes the commits.
+const defaultCommitTemplate = `Updating git & image references
+
+Rewritten by repoSed for the move from libapps-admin.uncw.edu to GitHub and Docker Hub.
+
+Rules:
+{{- range .Rules}}
+- {{.}}
+{{- end}}
+
+Files:
+{{- range .Files}}
+- {{.}}
+{{- end}}
+`
+
+// commitData is what a commit message template can use.
+type commitData struct {
+	RunID   string
+	Project string
+	Branch  string
+	Rules   []string
+	Files   []string
+}
+
+// parseCommitTemplate reads the message template at filename, or the default when filename is empty,
+// and checks that it executes.
+func parseCommitTemplate(filename string) (*template.Template, error) {
+	text := defaultCommitTemplate
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading commit template %s, %v", filename, err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("commit").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("commit template %s: %v", filename, err)
+	}
+	err = tmpl.Execute(&strings.Builder{}, commitData{Rules: []string{"rule"}, Files: []string{"file"}})
+	if err != nil {
+		return nil, fmt.Errorf("commit template %s: %v", filename, err)
+	}
+	return tmpl, nil
+}
+
+// parseIdentity splits "Name <email>" for -author.
+func parseIdentity(identity string) (name string, email string, err error) {
+	addr, err := mail.ParseAddress(identity)
+	if err != nil || addr.Name == "" {
+		return "", "", fmt.Errorf(`-author must look like "Name <email>", not %q`, identity)
+	}
+	return addr.Name, addr.Address, nil
+}
+
+// commitMessage renders the message for a rewrite commit and appends the Migration-Run and Migration-Rules trailers.
+func commitMessage(project Project, branch Branch, edits []*fileEdit, opts *options) (string, error) {
+	data := commitData{RunID: opts.runID, Project: project.PathWithNamespace, Branch: branch.Name, Rules: []string{}, Files: []string{}}
+	seen := map[string]bool{}
+	for _, edit := range edits {
+		data.Files = append(data.Files, edit.name)
+		for name := range edit.matches {
+			if !seen[name] {
+				seen[name] = true
+				data.Rules = append(data.Rules, name)
+			}
+		}
+	}
+	sort.Strings(data.Rules)
+
+	var sb strings.Builder
+	err := opts.commitTemplate.Execute(&sb, data)
+	if err != nil {
+		return "", fmt.Errorf("error rendering commit message, %v", err)
+	}
+	message := strings.TrimSpace(sb.String())
+	message += fmt.Sprintf("\n\n%s: %s\n%s: %s", migrationTrailer, opts.runID, rulesTrailer, strings.Join(data.Rules, ", "))
+	return message, nil
+}
+
+// gitIdentityArgs are the git -c settings that make commits use the configured identity and signing.
+// Anything not configured falls back to the operator's git config.
+func gitIdentityArgs(opts *options) []string {
+	args := []string{}
+	if opts.commitName != "" {
+		args = append(args, "-c", "user.name="+opts.commitName, "-c", "user.email="+opts.commitEmail)
+	}
+	switch opts.sign {
+	case "ssh":
+		args = append(args, "-c", "gpg.format=ssh", "-c", "commit.gpgsign=true")
+	case "gpg":
+		args = append(args, "-c", "gpg.format=openpgp", "-c", "commit.gpgsign=true")
+	}
+	if opts.signingKey != "" {
+		args = append(args, "-c", "user.signingkey="+opts.signingKey)
+	}
+	return args
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIdentity(t *testing.T) {
+	for _, tc := range []struct {
+		identity, name, email string
+	}{
+		{"Migration Bot <bot@uncw.edu>", "Migration Bot", "bot@uncw.edu"},
+		{`"Randall, Library" <lib@uncw.edu>`, "Randall, Library", "lib@uncw.edu"},
+		{"bot@uncw.edu", "", ""},
+		{"<bot@uncw.edu>", "", ""},
+		{"Migration Bot", "", ""},
+		{"Migration Bot <bot>", "", ""},
+		{"", "", ""},
+	} {
+		name, email, err := parseIdentity(tc.identity)
+		if tc.name == "" {
+			if err == nil || !strings.Contains(err.Error(), `"Name <email>"`) {
+				t.Errorf("%q: got %q %q %v, want an error", tc.identity, name, email, err)
+			}
+			continue
+		}
+		if err != nil || name != tc.name || email != tc.email {
+			t.Errorf("%q: got %q %q %v, want %q %q", tc.identity, name, email, err, tc.name, tc.email)
+		}
+	}
+}
+
+func writeCommitTemplate(t *testing.T, text string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "commit.tmpl")
+	if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCommitMessage(t *testing.T) {
+	project := Project{Name: "catalog", PathWithNamespace: "randall-dev/catalog"}
+	edits := []*fileEdit{
+		{name: "docker-compose.yml", matches: map[string]int{"compose-image": 1, "host": 2}},
+		{name: "README.md", matches: map[string]int{"host": 1}},
+	}
+	for _, tc := range []struct {
+		name     string
+		template string
+		edits    []*fileEdit
+		want     string
+	}{
+		{
+			name:  "default",
+			edits: edits,
+			want: "Updating git & image references\n\n" +
+				"Rewritten by repoSed for the move from libapps-admin.uncw.edu to GitHub and Docker Hub.\n\n" +
+				"Rules:\n- compose-image\n- host\n\nFiles:\n- docker-compose.yml\n- README.md\n\n" +
+				"Migration-Run: 20260101_120000\nMigration-Rules: compose-image, host",
+		},
+		{
+			name:     "custom",
+			template: "chore: migrate {{.Project}}@{{.Branch}} ({{.RunID}})\n\n{{len .Files}} files\n\n",
+			edits:    edits,
+			want:     "chore: migrate randall-dev/catalog@main (20260101_120000)\n\n2 files\n\nMigration-Run: 20260101_120000\nMigration-Rules: compose-image, host",
+		},
+		{
+			name:     "no rules",
+			template: "chore: migrate",
+			edits:    []*fileEdit{{name: ".gitlab-ci.yml"}},
+			want:     "chore: migrate\n\nMigration-Run: 20260101_120000\nMigration-Rules: ",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := ""
+			if tc.template != "" {
+				filename = writeCommitTemplate(t, tc.template)
+			}
+			tmpl, err := parseCommitTemplate(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := &options{runID: "20260101_120000", commitTemplate: tmpl}
+			message, err := commitMessage(project, Branch{Name: "main"}, tc.edits, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message != tc.want {
+				t.Errorf("message:\n%s\nwant:\n%s", message, tc.want)
+			}
+			// rollback finds the rewrite commits by their Migration-Run trailer
+			if !strings.Contains(message, "\n\n"+migrationTrailer+": 20260101_120000\n"+rulesTrailer+": ") {
+				t.Errorf("message lacks its trailers:\n%s", message)
+			}
+		})
+	}
+}
+
+func TestCommitTemplateErrors(t *testing.T) {
+	for _, tc := range []struct{ template, want string }{
+		{"{{.Project", "unclosed action"},
+		{"{{.Author}}", "can't evaluate field Author"},
+		{"{{index .Files 1}}", "index out of range"},
+	} {
+		if _, err := parseCommitTemplate(writeCommitTemplate(t, tc.template)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want an error containing %q", tc.template, err, tc.want)
+		}
+	}
+	if _, err := parseCommitTemplate(filepath.Join(t.TempDir(), "missing.tmpl")); err == nil || !strings.Contains(err.Error(), "error reading commit template") {
+		t.Errorf("missing template: got %v", err)
+	}
+
+	// a template that only fails on some commits fails when rendering them
+	tmpl, err := parseCommitTemplate(writeCommitTemplate(t, "migrate {{index .Rules 0}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &options{runID: "20260101_120000", commitTemplate: tmpl}
+	_, err = commitMessage(Project{}, Branch{Name: "main"}, []*fileEdit{{name: "file"}}, opts)
+	if err == nil || !strings.Contains(err.Error(), "error rendering commit message") {
+		t.Errorf("got %v, want a rendering error", err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+)
+
+// diffLog collects the diffs of a dry run along with counts for the summary.
+type diffLog struct {
+	mu           sync.Mutex
+	out          *os.File
+	projects     map[string]bool
+	branches     int
+	files        int
+	replacements int
+}
+
+// openDiffFile creates logs/dry-run-<runID>.diff, replacing any earlier one.
+func openDiffFile(runID string) *os.File {
+	diffpath := path.Join("logs", fmt.Sprintf("dry-run-%v.diff", runID))
+	diffFile, err := os.OpenFile(diffpath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open diff file: %v", err)
+	}
+	return diffFile
+}
+
+// record writes one diff per edited file, headed by the project and branch it came from.
+func (d *diffLog) record(project string, branch string, edits []*fileEdit) {
+	if len(edits) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.projects[project] = true
+	d.branches++
+	for _, edit := range edits {
+		d.files++
+		for _, count := range edit.matches {
+			d.replacements += count
+		}
+		fmt.Fprintf(d.out, "# project: %s branch: %s rules: %v\n", project, branch, edit.matches)
+		fmt.Fprint(d.out, unifiedDiff(edit.name, edit.before, edit.after))
+	}
+}
+
+func (d *diffLog) summary() string {
+	return fmt.Sprintf("Dry run\t%d projects, %d branches, %d files, %d replacements would change",
+		len(d.projects), d.branches, d.files, d.replacements)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(n int) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			sb.WriteString(strings.Repeat("x", i) + "\n")
+		}
+		return sb.String()
+	}
+	for name, tc := range map[string]struct{ before, after, want string }{
+		"equal": {"a\n", "a\n", ""},
+		"one line": {"a\nb\nc\n", "a\nB\nc\n",
+			"--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		"new file": {"", "a\n",
+			"--- /dev/null\n+++ b/f\n@@ -0,0 +1,1 @@\n+a\n"},
+		"no newline at end": {"a\nb", "a\nc",
+			"--- a/f\n+++ b/f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		"two hunks": {lines(12), strings.Replace(strings.Replace(lines(12), "x\n", "y\n", 1), "xxxxxxxxxxxx\n", "z\n", 1),
+			"--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n-x\n+y\n xx\n xxx\n xxxx\n" +
+				"@@ -9,4 +9,4 @@\n xxxxxxxxx\n xxxxxxxxxx\n xxxxxxxxxxx\n-xxxxxxxxxxxx\n+z\n"},
+	} {
+		if got := unifiedDiff("f", tc.before, tc.after); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, got, tc.want)
+		}
+	}
+}
+
+func TestDiffLog(t *testing.T) {
+	inTempDir(t)
+	if err := os.Mkdir("logs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	diffPath := filepath.Join("logs", "dry-run-20260101_120000.diff")
+	if err := os.WriteFile(diffPath, []byte(strings.Repeat("stale diff from an earlier run\n", 20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &diffLog{out: openDiffFile("20260101_120000"), projects: map[string]bool{}}
+	if d.out.Name() != diffPath {
+		t.Errorf("diff file is %s, want %s", d.out.Name(), diffPath)
+	}
+	d.record("catalog", "main", []*fileEdit{
+		{name: "README.md", before: readmeBefore, after: readmeAfter, matches: map[string]int{"readme-repo": 1}},
+		{name: "docker-compose.yml", before: composeBefore, after: composeAfter, matches: map[string]int{"compose-image": 1}},
+	})
+	d.record("plain", "main", nil)
+	d.out.Close()
+
+	if got := d.summary(); got != "Dry run\t1 projects, 1 branches, 2 files, 2 replacements would change" {
+		t.Errorf("summary %q", got)
+	}
+	data, err := os.ReadFile(diffPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# project: catalog branch: main rules: map[readme-repo:1]\n" + unifiedDiff("README.md", readmeBefore, readmeAfter) +
+		"# project: catalog branch: main rules: map[compose-image:1]\n" + unifiedDiff("docker-compose.yml", composeBefore, composeAfter)
+	if string(data) != want {
+		t.Errorf("diff file:\n%s\nwant\n%s", data, want)
+	}
+}
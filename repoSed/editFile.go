@@ -1,46 +1,101 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"path/filepath"
+	"slices"
 )
 
-func editFile(filepath string, needle string, replacement string) error {
-	log.Printf("Starting\teditFile on: %s with %s and %s", filepath, needle, replacement)
+// fileEdit is the result of running rules over one file.
+type fileEdit struct {
+	name    string
+	before  string
+	after   string
+	matches map[string]int
+	// images are the image references written by rules marked image
+	images []string
+}
+
+// editFile applies each rule to the file at name (relative to folder) in order.
+// The file is only written when something changed and dryRun is false, keeping its BOM, encoding and line endings.
+// It returns nil when no rule changed the file, and a *skipError for files it will not edit:
+// larger than maxSize bytes, binary, LFS pointers or encodings it can't write back unchanged.
+func editFile(logger *log.Logger, folder string, name string, rules []rule, maxSize int64, dryRun bool) (*fileEdit, error) {
+	fullpath := filepath.Join(folder, name)
+	logger.Printf("Starting\teditFile on: %s with %d rules", fullpath, len(rules))
 
-	_, err := os.Stat(filepath)
+	info, err := os.Stat(fullpath)
 	if os.IsNotExist(err) {
-		log.Printf("Skipping. File does not exist: %s", filepath)
-		return nil
+		logger.Printf("Skipping. File does not exist: %s", fullpath)
+		return nil, nil
 	}
-
-	fileBytes, err := os.ReadFile(filepath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	filetext := string(fileBytes)
-
-	re := regexp.MustCompile(needle)
-	matches := re.FindStringSubmatch(filetext)
-	if len(matches) < 2 {
-		// ok to not find the needle, continue to next file
-		log.Printf("Info\tNot Found Needle '%s' in file '%s'", needle, filepath)
-		return nil
+	if info.Size() > maxSize {
+		return nil, &skipError{reason: fmt.Sprintf("larger than %d bytes", maxSize)}
 	}
 
-	log.Printf("Info\tMatches\t%v", matches)
-
-	newtext := re.ReplaceAllString(filetext, fmt.Sprintf(replacement, matches[1]))
-
-	info, err := os.Stat(filepath)
+	fileBytes, err := os.ReadFile(fullpath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	edit, out, err := rewriteContent(logger, name, fileBytes, rules)
+	if err != nil || edit == nil {
+		return nil, err
+	}
+	if dryRun {
+		return edit, nil
 	}
-	err = os.WriteFile(filepath, []byte(newtext), info.Mode())
+	err = os.WriteFile(fullpath, out, info.Mode())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return edit, nil
+}
+
+// rewriteContent runs the rules over one file's bytes and returns the edit with the bytes to write back,
+// or a nil edit when no rule changed anything. Content editFile must not touch gets a *skipError.
+func rewriteContent(logger *log.Logger, name string, data []byte, rules []rule) (*fileEdit, []byte, error) {
+	if bytes.HasPrefix(data, []byte(lfsPointerPrefix)) {
+		return nil, nil, &skipError{reason: "LFS pointer"}
+	}
+	filetext, format, reason := decodeText(data)
+	if reason != "" {
+		return nil, nil, &skipError{reason: reason}
+	}
+	before := filetext
+
+	matches := map[string]int{}
+	images := []string{}
+	for _, r := range rules {
+		newtext, spans, err := r.apply(filetext)
+		if err != nil {
+			return nil, nil, err
+		}
+		count := len(spans)
+		if count == 0 {
+			// ok to not find the needle, continue to next rule
+			logger.Printf("Info\tNot Found Needle '%s' in file '%s'", r.re, name)
+			continue
+		}
+		logger.Printf("Info\tRule %s matched %d times in %s", r.Name, count, name)
+		matches[r.Name] = count
+		if r.Image {
+			for _, span := range spans {
+				if ref := imageRefAt(newtext, span[0], span[1]); ref != "" && !slices.Contains(images, ref) {
+					images = append(images, ref)
+				}
+			}
+		}
+		filetext = newtext
+	}
+	if filetext == before {
+		return nil, nil, nil
 	}
-	return nil
+	edit := &fileEdit{name: name, before: format.lineEndings(before), after: format.lineEndings(filetext), matches: matches, images: images}
+	return edit, format.encode(filetext), nil
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeToken is the LIBAPPS_ADMIN_TOKEN the fake GitLab expects on every request.
+const fakeToken = "test-token"
+
+// fakeGitLab serves the GitLab v4 endpoints repoSed uses, for projects backed by bare repos on disk.
+type fakeGitLab struct {
+	t       *testing.T
+	server  *httptest.Server
+	repoDir string
+
+	mu            sync.Mutex
+	projects      []Project
+	branches      map[int][]Branch
+	images        map[int][]Image
+	mergeRequests []newMergeRequest
+}
+
+// newFakeGitLab starts a fake GitLab and points the test's git at a throwaway home with a fixed identity.
+func newFakeGitLab(t *testing.T) *fakeGitLab {
+	t.Helper()
+	home := t.TempDir()
+	err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[user]\n\tname = Fixture\n\temail = fixture@example.edu\n[init]\n\tdefaultBranch = main\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("LIBAPPS_ADMIN_TOKEN", fakeToken)
+
+	f := &fakeGitLab{t: t, repoDir: t.TempDir(), branches: map[int][]Branch{}, images: map[int][]Image{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects", f.listProjects)
+	mux.HandleFunc("GET /api/v4/projects/{id}/repository/branches", f.listBranches)
+	mux.HandleFunc("GET /api/v4/projects/{id}/registry/repositories", f.listImages)
+	mux.HandleFunc("POST /api/v4/projects/{id}/merge_requests", f.openMergeRequest)
+	f.server = httptest.NewServer(f.authorized(mux))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeGitLab) apiURL() string {
+	return f.server.URL + "/api/v4"
+}
+
+func (f *fakeGitLab) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != fakeToken {
+			http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addProject creates a bare repo whose branches hold the given files, the first branch being the default,
+// and lists it as a project. Files are path to content.
+func (f *fakeGitLab) addProject(name string, branches []string, files map[string]map[string]string, images ...string) Project {
+	f.t.Helper()
+	work := filepath.Join(f.t.TempDir(), name)
+	bare := filepath.Join(f.repoDir, name+".git")
+	gitRun(f.t, ".", "init", "-q", "-b", branches[0], work)
+	for i, branch := range branches {
+		if i > 0 {
+			gitRun(f.t, work, "checkout", "-q", "-b", branch, branches[0])
+		}
+		for path, content := range files[branch] {
+			full := filepath.Join(work, path)
+			err := os.MkdirAll(filepath.Dir(full), 0755)
+			if err != nil {
+				f.t.Fatal(err)
+			}
+			err = os.WriteFile(full, []byte(content), 0644)
+			if err != nil {
+				f.t.Fatal(err)
+			}
+		}
+		gitRun(f.t, work, "add", "-A")
+		gitRun(f.t, work, "commit", "-q", "--allow-empty", "-m", "fixture "+branch)
+	}
+	gitRun(f.t, work, "checkout", "-q", branches[0])
+	gitRun(f.t, ".", "clone", "-q", "--bare", work, bare)
+	return f.list(name, bare, repoBranches(f.t, bare), images...)
+}
+
+// list adds a project to the listing with the given branches; url may name no repo at all.
+func (f *fakeGitLab) list(name string, url string, branches []Branch, images ...string) Project {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := len(f.projects) + 1
+	project := Project{
+		ID:                id,
+		Name:              name,
+		URL:               url,
+		Visibility:        "internal",
+		PathWithNamespace: "randall-dev/" + name,
+		Links:             Links{RepoBranches: fmt.Sprintf("%s/projects/%d/repository/branches", f.apiURL(), id)},
+	}
+	f.projects = append(f.projects, project)
+	f.branches[id] = branches
+	for _, image := range images {
+		f.images[id] = append(f.images[id], Image{Name: image})
+	}
+	return project
+}
+
+// repoBranches reads a bare repo's branches the way GitLab reports them.
+func repoBranches(t *testing.T, bare string) []Branch {
+	t.Helper()
+	head := gitRun(t, bare, "symbolic-ref", "--short", "HEAD")
+	refs := gitRun(t, bare, "for-each-ref", "--format=%(refname:short) %(objectname) %(committerdate:iso-strict)", "refs/heads")
+	branches := []Branch{}
+	for _, line := range strings.Split(refs, "\n") {
+		fields := strings.Fields(line)
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		branches = append(branches, Branch{Name: fields[0], Default: fields[0] == head, Commit: BranchCommit{ID: fields[1], CommittedDate: date}})
+	}
+	return branches
+}
+
+func (f *fakeGitLab) project(r *http.Request) (Project, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil || id < 1 || id > len(f.projects) {
+		return Project{}, false
+	}
+	return f.projects[id-1], true
+}
+
+func (f *fakeGitLab) listProjects(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 20
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	start := min((page-1)*perPage, len(f.projects))
+	end := min(start+perPage, len(f.projects))
+	writeJSON(w, http.StatusOK, f.projects[start:end])
+}
+
+// protect marks a project's branch as protected, as GitLab's branch protection would.
+func (f *fakeGitLab) protect(project Project, branch string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.branches[project.ID] {
+		if f.branches[project.ID][i].Name == branch {
+			f.branches[project.ID][i].Protected = true
+		}
+	}
+}
+
+func (f *fakeGitLab) listBranches(w http.ResponseWriter, r *http.Request) {
+	project, ok := f.project(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writeJSON(w, http.StatusOK, f.branches[project.ID])
+}
+
+func (f *fakeGitLab) listImages(w http.ResponseWriter, r *http.Request) {
+	project, ok := f.project(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	images := f.images[project.ID]
+	if images == nil {
+		images = []Image{}
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+func (f *fakeGitLab) openMergeRequest(w http.ResponseWriter, r *http.Request) {
+	project, ok := f.project(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var mr newMergeRequest
+	err := json.NewDecoder(r.Body).Decode(&mr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.mergeRequests = append(f.mergeRequests, mr)
+	n := len(f.mergeRequests)
+	f.mu.Unlock()
+	writeJSON(w, http.StatusCreated, mergeRequest{WebURL: fmt.Sprintf("%s/%s/-/merge_requests/%d", f.server.URL, project.PathWithNamespace, n)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// gitRun runs git in dir and returns its trimmed output, failing the test on error.
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s in %s: %v\n%s", strings.Join(args, " "), dir, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// branchTips maps each branch of a repo to its commit.
+func branchTips(t *testing.T, repo string) map[string]string {
+	t.Helper()
+	tips := map[string]string{}
+	out := gitRun(t, repo, "for-each-ref", "--format=%(refname:short) %(objectname)", "refs/heads")
+	for _, line := range strings.Split(out, "\n") {
+		if name, sha, ok := strings.Cut(line, " "); ok {
+			tips[name] = sha
+		}
+	}
+	return tips
+}
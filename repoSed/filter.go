@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// projectFilter decides which fetched projects a run touches. Zero values match everything.
+type projectFilter struct {
+	include      *regexp.Regexp
+	exclude      *regexp.Regexp
+	pathPrefixes []string
+	archived     string
+	visibility   []string
+	listed       map[string]bool
+}
+
+// parse fills the filter from its command line flag values.
+func (f *projectFilter) parse(include string, exclude string, pathPrefixes string, visibility string, projectList string) error {
+	var err error
+	if include != "" {
+		f.include, err = regexp.Compile(include)
+		if err != nil {
+			return fmt.Errorf("invalid -include %q: %v", include, err)
+		}
+	}
+	if exclude != "" {
+		f.exclude, err = regexp.Compile(exclude)
+		if err != nil {
+			return fmt.Errorf("invalid -exclude %q: %v", exclude, err)
+		}
+	}
+	if f.archived != "include" && f.archived != "exclude" && f.archived != "only" {
+		return fmt.Errorf(`-archived must be "include", "exclude" or "only", not %q`, f.archived)
+	}
+	f.pathPrefixes = splitList(pathPrefixes)
+	f.visibility = splitList(visibility)
+	if projectList != "" {
+		f.listed, err = readProjectList(projectList)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match reports whether the project passes every filter, and which one it failed if not.
+func (f projectFilter) match(project Project) (bool, string) {
+	if f.include != nil && !f.include.MatchString(project.Name) {
+		return false, fmt.Sprintf("name does not match -include %s", f.include)
+	}
+	if f.exclude != nil && f.exclude.MatchString(project.Name) {
+		return false, fmt.Sprintf("name matches -exclude %s", f.exclude)
+	}
+	if len(f.pathPrefixes) > 0 {
+		found := false
+		for _, prefix := range f.pathPrefixes {
+			if strings.HasPrefix(project.PathWithNamespace, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, fmt.Sprintf("path %s does not start with -path-prefix %v", project.PathWithNamespace, f.pathPrefixes)
+		}
+	}
+	switch {
+	case f.archived == "exclude" && project.Archived:
+		return false, "archived"
+	case f.archived == "only" && !project.Archived:
+		return false, "not archived"
+	}
+	if len(f.visibility) > 0 {
+		found := false
+		for _, v := range f.visibility {
+			if v == project.Visibility {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, fmt.Sprintf("visibility %s not in -visibility %v", project.Visibility, f.visibility)
+		}
+	}
+	if f.listed != nil && !f.listed[project.Name] && !f.listed[project.PathWithNamespace] {
+		return false, "not in -projects list"
+	}
+	return true, ""
+}
+
+// filterProjects returns the projects that pass the filter, logging each one left out.
+func filterProjects(projects []Project, f projectFilter) []Project {
+	kept := []Project{}
+	for _, project := range projects {
+		ok, reason := f.match(project)
+		if !ok {
+			log.Printf("Filtered\t%s: %s", project.PathWithNamespace, reason)
+			continue
+		}
+		kept = append(kept, project)
+	}
+	log.Printf("Info\t%d of %d projects selected", len(kept), len(projects))
+	return kept
+}
+
+// readProjectList reads project names or namespaced paths, one per line.
+// Blank lines and lines starting with # are ignored.
+func readProjectList(filename string) (map[string]bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening project list %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	listed := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		listed[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading project list %s: %v", filename, err)
+	}
+	return listed, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// branchPolicy decides which of a project's branches a run rewrites. Zero values match every branch.
+// The default branch is never skipped for its age or for being merged.
+type branchPolicy struct {
+	mode      string
+	match     *regexp.Regexp
+	newerThan int
+	unmerged  bool
+}
+
+// parse fills the policy from its command line flag values.
+func (p *branchPolicy) parse(match string) error {
+	if p.mode != "all" && p.mode != "default" {
+		return fmt.Errorf(`-branches must be "all" or "default", not %q`, p.mode)
+	}
+	if match != "" {
+		var err error
+		p.match, err = regexp.Compile(match)
+		if err != nil {
+			return fmt.Errorf("invalid -branch-match %q: %v", match, err)
+		}
+	}
+	if p.newerThan < 0 {
+		return fmt.Errorf("-newer-than must be a number of days, not %d", p.newerThan)
+	}
+	return nil
+}
+
+// allows reports whether the branch passes the policy, and why not if it doesn't.
+func (p branchPolicy) allows(branch Branch, now time.Time) (bool, string) {
+	if p.mode == "default" && !branch.Default {
+		return false, "not the default branch"
+	}
+	if p.match != nil && !p.match.MatchString(branch.Name) {
+		return false, fmt.Sprintf("name does not match -branch-match %s", p.match)
+	}
+	if branch.Default {
+		return true, ""
+	}
+	if p.newerThan > 0 && branch.Commit.CommittedDate.Before(now.AddDate(0, 0, -p.newerThan)) {
+		return false, fmt.Sprintf("last commit %s is older than %d days", branch.Commit.CommittedDate.Format("2006-01-02"), p.newerThan)
+	}
+	if p.unmerged && branch.Merged {
+		return false, "already merged into the default branch"
+	}
+	return true, ""
+}
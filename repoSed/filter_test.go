@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBranchPolicyAllows(t *testing.T) {
+	now := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	branch := func(name string, isDefault bool, merged bool, daysOld int) Branch {
+		return Branch{Name: name, Default: isDefault, Merged: merged, Commit: BranchCommit{CommittedDate: now.AddDate(0, 0, -daysOld)}}
+	}
+	main := branch("main", true, false, 400)
+	for _, tc := range []struct {
+		name   string
+		policy branchPolicy
+		branch Branch
+		reason string
+	}{
+		{"zero policy", branchPolicy{}, branch("old", false, true, 400), ""},
+		{"all", branchPolicy{mode: "all"}, branch("feature", false, false, 1), ""},
+		{"default mode keeps the default", branchPolicy{mode: "default"}, main, ""},
+		{"default mode skips others", branchPolicy{mode: "default"}, branch("feature", false, false, 1), "not the default branch"},
+		{"match", branchPolicy{match: regexp.MustCompile(`^release/`)}, branch("release/1.0", false, false, 1), ""},
+		{"no match", branchPolicy{match: regexp.MustCompile(`^release/`)}, branch("feature", false, false, 1), "name does not match -branch-match ^release/"},
+		// the default branch is exempt from age and merge checks, but not from -branch-match
+		{"match applies to the default", branchPolicy{match: regexp.MustCompile(`^release/`)}, main, "name does not match -branch-match ^release/"},
+		{"newer than", branchPolicy{newerThan: 30}, branch("recent", false, false, 29), ""},
+		{"older than", branchPolicy{newerThan: 30}, branch("stale", false, false, 31), "last commit 2025-12-31 is older than 30 days"},
+		{"old default", branchPolicy{newerThan: 30}, main, ""},
+		{"unmerged", branchPolicy{unmerged: true}, branch("open", false, false, 1), ""},
+		{"merged", branchPolicy{unmerged: true}, branch("done", false, true, 1), "already merged into the default branch"},
+		{"merged default", branchPolicy{unmerged: true}, branch("main", true, true, 1), ""},
+		{"age is checked before merge", branchPolicy{newerThan: 30, unmerged: true}, branch("done", false, true, 31), "last commit 2025-12-31 is older than 30 days"},
+	} {
+		ok, reason := tc.policy.allows(tc.branch, now)
+		if ok != (tc.reason == "") || reason != tc.reason {
+			t.Errorf("%s: allows = %v, %q, want %q", tc.name, ok, reason, tc.reason)
+		}
+	}
+}
+
+// filterFixture lists projects across namespaces, visibilities and archive states in the fake GitLab and fetches them back.
+func filterFixture(t *testing.T) []Project {
+	t.Helper()
+	f := newFakeGitLab(t)
+	// fetching writes the project list to the working directory
+	inTempDir(t)
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	for _, p := range []Project{
+		{Name: "catalog", PathWithNamespace: "randall-dev/catalog", Visibility: "internal"},
+		{Name: "catalog-old", PathWithNamespace: "randall-dev/catalog-old", Visibility: "internal", Archived: true},
+		{Name: "web", PathWithNamespace: "special-collections/web", Visibility: "public"},
+		{Name: "notes", PathWithNamespace: "randall-dev-archive/notes", Visibility: "private"},
+	} {
+		f.list(p.Name, "", nil)
+		f.projects[len(f.projects)-1].PathWithNamespace = p.PathWithNamespace
+		f.projects[len(f.projects)-1].Visibility = p.Visibility
+		f.projects[len(f.projects)-1].Archived = p.Archived
+	}
+	projects, err := fetchLibappsProjects(f.apiURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return projects
+}
+
+func TestProjectFilterMatch(t *testing.T) {
+	projects := filterFixture(t)
+	listFile := filepath.Join(t.TempDir(), "projects.txt")
+	if err := os.WriteFile(listFile, []byte("# wanted\ncatalog\n\nspecial-collections/web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name                                         string
+		include, exclude, prefixes, visibility, list string
+		archived                                     string
+		want                                         []string
+	}{
+		{name: "everything", archived: "include", want: []string{"catalog", "catalog-old", "web", "notes"}},
+		{name: "include", include: "^catalog", archived: "include", want: []string{"catalog", "catalog-old"}},
+		{name: "exclude", exclude: "-old$", archived: "include", want: []string{"catalog", "web", "notes"}},
+		{name: "include and exclude", include: "^catalog", exclude: "-old$", archived: "include", want: []string{"catalog"}},
+		// a prefix is a plain string prefix of the path, so randall-dev/ leaves out randall-dev-archive
+		{name: "path prefix", prefixes: "randall-dev/", archived: "include", want: []string{"catalog", "catalog-old"}},
+		{name: "path prefixes", prefixes: "special-collections/, randall-dev-archive/", archived: "include", want: []string{"web", "notes"}},
+		{name: "exclude archived", archived: "exclude", want: []string{"catalog", "web", "notes"}},
+		{name: "only archived", archived: "only", want: []string{"catalog-old"}},
+		{name: "visibility", visibility: "public,private", archived: "include", want: []string{"web", "notes"}},
+		{name: "projects list by name or path", list: listFile, archived: "include", want: []string{"catalog", "web"}},
+		{name: "every filter must pass", include: "a", prefixes: "randall-dev", visibility: "internal", list: listFile, archived: "exclude", want: []string{"catalog"}},
+	} {
+		var f projectFilter
+		f.archived = tc.archived
+		if err := f.parse(tc.include, tc.exclude, tc.prefixes, tc.visibility, tc.list); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := []string{}
+		for _, project := range filterProjects(projects, f) {
+			got = append(got, project.Name)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: kept %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProjectFilterReasons(t *testing.T) {
+	projects := filterFixture(t)
+	var f projectFilter
+	f.archived = "exclude"
+	if err := f.parse("", "^web$", "randall-dev", "internal", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"",
+		"archived",
+		"name matches -exclude ^web$",
+		"visibility private not in -visibility [internal]",
+	}
+	for i, project := range projects {
+		ok, reason := f.match(project)
+		if ok != (want[i] == "") || reason != want[i] {
+			t.Errorf("%s: match = %v, %q, want %q", project.Name, ok, reason, want[i])
+		}
+	}
+	if err := f.parse("(", "", "", "", ""); err == nil {
+		t.Error("invalid -include was accepted")
+	}
+	f.archived = "sometimes"
+	if err := f.parse("", "", "", "", ""); err == nil {
+		t.Error("invalid -archived was accepted")
+	}
+}
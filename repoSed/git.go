@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// Errors a gitClient returns for conditions callers act on, so nobody has to match git's output text.
+var (
+	errAlreadyCloned   = errors.New("destination already exists and is not empty")
+	errNothingToCommit = errors.New("nothing to commit")
+	errNotFastForward  = errors.New("branch has diverged from its upstream")
+	errPushRejected    = errors.New("push rejected")
+	errUnsupported     = errors.New("not supported in process")
+)
+
+// gitClient is the set of git operations the branch loop runs.
+// goGit does them in process; execGit runs the git binary and covers whatever goGit can't.
+type gitClient interface {
+	// clone clones url into folder, or returns errAlreadyCloned if folder holds something already.
+	clone(logger *log.Logger, url string, folder string) error
+	// fetchPull fetches every remote and fast-forwards the checked out branch.
+	fetchPull(logger *log.Logger, folder string) error
+	// checkout switches to branch, creating it from origin's branch if there is no local one.
+	checkout(logger *log.Logger, folder string, branch string) error
+	// clean reports whether the working tree has no changes.
+	clean(logger *log.Logger, folder string) (bool, error)
+	// commitAll commits every change in the working tree and returns the new commit,
+	// or errNothingToCommit.
+	commitAll(logger *log.Logger, folder string, message string, opts *options) (string, error)
+	// head returns the checked out commit.
+	head(logger *log.Logger, folder string) (string, error)
+	// remoteBranch returns the last fetched commit of remote's branch, or "" if there is none.
+	remoteBranch(logger *log.Logger, folder string, remote string, branch string) (string, error)
+	// push pushes HEAD to remote's branch, only if that branch is still at expected ("" meaning it must not exist).
+	push(logger *log.Logger, folder string, remote string, branch string, expected string) error
+}
+
+// newGitClient returns the client for -git: "go" for in process with the git binary as fallback, or "exec".
+func newGitClient(name string) (gitClient, error) {
+	switch name {
+	case "go":
+		return fallbackGit{primary: goGit{}, fallback: execGit{}}, nil
+	case "exec":
+		return execGit{}, nil
+	}
+	return nil, fmt.Errorf(`-git must be "go" or "exec", not %q`, name)
+}
+
+// nonEmptyDir reports whether folder exists and has anything in it.
+func nonEmptyDir(folder string) bool {
+	entries, err := os.ReadDir(folder)
+	return err == nil && len(entries) > 0
+}
+
+// execGit runs the git binary.
+type execGit struct{}
+
+func (execGit) clone(logger *log.Logger, url string, folder string) error {
+	if nonEmptyDir(folder) {
+		return errAlreadyCloned
+	}
+	logger.Printf("Running\tcommand: git clone %s %s", url, folder)
+	output, err := exec.Command("git", "clone", url, folder).CombinedOutput()
+	if err != nil {
+		logger.Printf("%s", output)
+	}
+	return err
+}
+
+func (execGit) fetchPull(logger *log.Logger, folder string) error {
+	_, err := runCommand(logger, folder, "git", "fetch", "--all")
+	if err != nil {
+		return err
+	}
+	_, err = runCommand(logger, folder, "git", "pull", "--all")
+	return err
+}
+
+func (execGit) checkout(logger *log.Logger, folder string, branch string) error {
+	_, err := runCommand(logger, folder, "git", "checkout", branch)
+	return err
+}
+
+func (execGit) clean(logger *log.Logger, folder string) (bool, error) {
+	status, err := runCommand(logger, folder, "git", "status", "--porcelain")
+	return status == "", err
+}
+
+func (g execGit) commitAll(logger *log.Logger, folder string, message string, opts *options) (string, error) {
+	_, err := runCommand(logger, folder, "git", "add", ".")
+	if err != nil {
+		return "", err
+	}
+	clean, err := g.clean(logger, folder)
+	if err != nil {
+		return "", err
+	}
+	if clean {
+		return "", errNothingToCommit
+	}
+	args := append(gitIdentityArgs(opts), "commit", "-m", message)
+	_, err = runCommand(logger, folder, "git", args...)
+	if err != nil {
+		return "", err
+	}
+	return g.head(logger, folder)
+}
+
+func (execGit) head(logger *log.Logger, folder string) (string, error) {
+	return runCommand(logger, folder, "git", "rev-parse", "HEAD")
+}
+
+func (execGit) remoteBranch(logger *log.Logger, folder string, remote string, branch string) (string, error) {
+	// rev-parse --verify --quiet fails silently when the ref doesn't exist
+	sha, err := runCommand(logger, folder, "git", "rev-parse", "--verify", "--quiet", fmt.Sprintf("refs/remotes/%s/%s", remote, branch))
+	if err != nil {
+		return "", nil
+	}
+	return sha, nil
+}
+
+func (execGit) push(logger *log.Logger, folder string, remote string, branch string, expected string) error {
+	lease := fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", branch, expected)
+	_, err := runCommand(logger, folder, "git", "push", lease, remote, fmt.Sprintf("HEAD:refs/heads/%s", branch))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPushRejected, err)
+	}
+	return nil
+}
+
+// fallbackGit tries primary first and hands anything it reports as errUnsupported to fallback.
+type fallbackGit struct {
+	primary  gitClient
+	fallback gitClient
+}
+
+func (f fallbackGit) use(logger *log.Logger, err error) bool {
+	if errors.Is(err, errUnsupported) {
+		logger.Printf("Info\t%v, using the git binary", err)
+		return true
+	}
+	return false
+}
+
+func (f fallbackGit) clone(logger *log.Logger, url string, folder string) error {
+	err := f.primary.clone(logger, url, folder)
+	if f.use(logger, err) {
+		return f.fallback.clone(logger, url, folder)
+	}
+	return err
+}
+
+func (f fallbackGit) fetchPull(logger *log.Logger, folder string) error {
+	err := f.primary.fetchPull(logger, folder)
+	if f.use(logger, err) {
+		return f.fallback.fetchPull(logger, folder)
+	}
+	return err
+}
+
+func (f fallbackGit) checkout(logger *log.Logger, folder string, branch string) error {
+	err := f.primary.checkout(logger, folder, branch)
+	if f.use(logger, err) {
+		return f.fallback.checkout(logger, folder, branch)
+	}
+	return err
+}
+
+func (f fallbackGit) clean(logger *log.Logger, folder string) (bool, error) {
+	clean, err := f.primary.clean(logger, folder)
+	if f.use(logger, err) {
+		return f.fallback.clean(logger, folder)
+	}
+	return clean, err
+}
+
+func (f fallbackGit) commitAll(logger *log.Logger, folder string, message string, opts *options) (string, error) {
+	sha, err := f.primary.commitAll(logger, folder, message, opts)
+	if f.use(logger, err) {
+		return f.fallback.commitAll(logger, folder, message, opts)
+	}
+	return sha, err
+}
+
+func (f fallbackGit) head(logger *log.Logger, folder string) (string, error) {
+	sha, err := f.primary.head(logger, folder)
+	if f.use(logger, err) {
+		return f.fallback.head(logger, folder)
+	}
+	return sha, err
+}
+
+func (f fallbackGit) remoteBranch(logger *log.Logger, folder string, remote string, branch string) (string, error) {
+	sha, err := f.primary.remoteBranch(logger, folder, remote, branch)
+	if f.use(logger, err) {
+		return f.fallback.remoteBranch(logger, folder, remote, branch)
+	}
+	return sha, err
+}
+
+func (f fallbackGit) push(logger *log.Logger, folder string, remote string, branch string, expected string) error {
+	err := f.primary.push(logger, folder, remote, branch, expected)
+	if f.use(logger, err) {
+		return f.fallback.push(logger, folder, remote, branch, expected)
+	}
+	return err
+}
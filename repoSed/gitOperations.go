@@ -1,72 +1,143 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
-	"strings"
 )
 
-func gitClone(folder string, project Project) error {
-	log.Printf("Cloning %s", project.Name)
-	cmd := exec.Command("git", "clone", project.URL, folder)
-	output, err := cmd.CombinedOutput()
-	if strings.Contains(string(output), "already exists and is not an empty directory") {
-		log.Printf("Not pulling %s because it already exists\n", project.Name)
+func gitClone(logger *log.Logger, folder string, project Project, client gitClient) error {
+	logger.Printf("Cloning %s", project.Name)
+	err := client.clone(logger, project.URL, folder)
+	if errors.Is(err, errAlreadyCloned) {
+		logger.Printf("Not pulling %s because it already exists\n", project.Name)
 		return nil
 	}
 	if err != nil {
-		log.Printf("Failed to clone repository: %s", project.URL)
-		log.Printf("%s", output)
+		logger.Printf("Failed to clone repository: %s", project.URL)
+		logger.Printf("Error\t%v", err)
 		return fmt.Errorf("error cloning repository %s", project.URL)
 	}
 	return nil
 }
 
-func gitFetchPull(folder string) error {
-	log.Printf("Fetching and pulling in %s", folder)
-	_, err := runCommand(folder, "git", "fetch", "--all")
+func gitFetchPull(logger *log.Logger, folder string, client gitClient) error {
+	logger.Printf("Fetching and pulling in %s", folder)
+	err := client.fetchPull(logger, folder)
 	if err != nil {
-		log.Printf("Error\t%v", err)
-		return fmt.Errorf("error fetching in folder %s", folder)
-	}
-	_, err = runCommand(folder, "git", "pull", "--all")
-	if err != nil {
-		log.Printf("Error\t%v", err)
-		return fmt.Errorf("error pulling in folder %s", folder)
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error fetching and pulling in folder %s", folder)
 	}
 	return nil
 }
 
-func checkoutBranch(folder string, branch Branch) error {
-	log.Printf("branch is: %v", branch)
-	_, err := runCommand(folder, "git", "checkout", branch.Name)
+func checkoutBranch(logger *log.Logger, folder string, branch Branch, client gitClient) error {
+	logger.Printf("branch is: %v", branch)
+	err := client.checkout(logger, folder, branch.Name)
 	if err != nil {
-		log.Printf("Error\t%v", err)
+		logger.Printf("Error\t%v", err)
 		return fmt.Errorf("error checking out branch %s in folder %s", branch.Name, folder)
 	}
 	return nil
 }
 
-func commitAndPushBranch(folder string) error {
-	_, err := runCommand(folder, "git", "add", ".")
+// commitChanges commits everything in the working tree and returns the new commit, or "" if there was nothing to commit.
+// The commit carries Migration-Run and Migration-Rules trailers so rollback and auditors can find it later.
+func commitChanges(logger *log.Logger, folder string, project Project, branch Branch, edits []*fileEdit, opts *options) (sha string, err error) {
+	message, err := commitMessage(project, branch, edits, opts)
+	if err != nil {
+		return "", err
+	}
+	sha, err = opts.git.commitAll(logger, folder, message, opts)
+	if errors.Is(err, errNothingToCommit) {
+		logger.Printf("Info\tNothing to commit in folder %s", folder)
+		return "", nil
+	}
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return "", fmt.Errorf("error committing changes in folder %s", folder)
+	}
+	return sha, nil
+}
+
+// commitAndPushBranch commits the edits and returns the new commit, or "" if there was nothing to commit.
+func commitAndPushBranch(logger *log.Logger, folder string, project Project, branch Branch, edits []*fileEdit, opts *options) (commit string, err error) {
+	commit, err = commitChanges(logger, folder, project, branch, edits, opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.pushRemote == "" {
+		return commit, nil
+	}
+	if branch.Protected && !opts.allowProtected {
+		logger.Printf("Info\tNot pushing protected branch %s in folder %s", branch.Name, folder)
+		opts.pushes.record(project, branch.Name, opts.pushRemote, "", "refused: protected branch")
+		return commit, &skipError{reason: "protected branch, not pushed", blocked: true}
+	}
+	err = blockPushForSecrets(logger, project, branch, opts)
+	if err != nil {
+		return commit, err
+	}
+	sha, err := pushBranch(logger, folder, branch, opts.pushRemote, project.LFS, opts.git)
+	if err != nil {
+		opts.pushes.record(project, branch.Name, opts.pushRemote, "", "failed")
+		return commit, err
+	}
+	if sha == "" {
+		opts.pushes.record(project, branch.Name, opts.pushRemote, "", "up to date")
+		return commit, nil
+	}
+	opts.pushes.record(project, branch.Name, opts.pushRemote, sha, "pushed")
+	return commit, nil
+}
+
+// pushBranch pushes the checked out branch to remote with a lease on the remote branch,
+// so the push fails if the remote branch moved since it was last fetched.
+// It returns the pushed commit, or "" if the remote already had it.
+func pushBranch(logger *log.Logger, folder string, branch Branch, remote string, lfs bool, client gitClient) (sha string, err error) {
+	sha, err = client.head(logger, folder)
+	if err != nil {
+		return "", fmt.Errorf("error reading HEAD in folder %s", folder)
+	}
+	// an empty expected value means the branch must not exist on the remote yet
+	expected, err := client.remoteBranch(logger, folder, remote, branch.Name)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s/%s in folder %s", remote, branch.Name, folder)
+	}
+	if expected == sha {
+		logger.Printf("Info\tRemote %s already has %s at %s", remote, branch.Name, sha)
+		return "", nil
+	}
+
+	if lfs {
+		err = pushLFS(logger, folder, branch.Name, remote)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = client.push(logger, folder, remote, branch.Name, expected)
 	if err != nil {
-		log.Printf("Error\t%v", err)
-		return fmt.Errorf("error adding files in folder %s", folder)
+		logger.Printf("Error\t%v", err)
+		return "", fmt.Errorf("error pushing branch %s to %s in folder %s", branch.Name, remote, folder)
 	}
-	output, err := runCommand(folder, "git", "commit", "-m", `"Updating git & image references"`)
-	if strings.Contains(output, "nothing to commit") {
-		log.Printf("Info\tNothing to commit in folder %s", folder)
+	logger.Printf("Info\tPushed %s to %s at %s", branch.Name, remote, sha)
+	return sha, nil
+}
+
+// setupRemote makes sure remote exists in the clone.
+// "origin" is the GitLab clone source; "github" is added pointing at the project's GitHub repo.
+func setupRemote(logger *log.Logger, folder string, project Project, remote string, opts *options) error {
+	if remote == "" || remote == "origin" {
 		return nil
 	}
+	url := destinationURL(project, remote, opts)
+	_, err := runCommand(logger, folder, "git", "remote", "add", remote, url)
+	if err != nil {
+		_, err = runCommand(logger, folder, "git", "remote", "set-url", remote, url)
+	}
 	if err != nil {
-		log.Printf("Error\t%v", err)
-		return fmt.Errorf("error committing changes in folder %s", folder)
-	}
-	// _, err = runCommand(folder, "git", "push")
-	// if err != nil {
-	// 	log.Printf("Error\t%v", err)
-	// 	return fmt.Errorf("error pushing changes in folder %s", folder)
-	// }
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error setting remote %s to %s in folder %s", remote, url, folder)
+	}
 	return nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+type newPullRequest struct {
+	Title string `json:"title"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Body  string `json:"body"`
+}
+
+type pullRequest struct {
+	HTMLURL string `json:"html_url"`
+}
+
+// openPullRequest opens a pull request on the project's GitHub repo and returns its URL.
+func openPullRequest(project Project, head string, base string, title string, body string) (string, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls", githubOrg, project.Name)
+	jsonData, err := json.Marshal(newPullRequest{Title: title, Head: head, Base: base, Body: body})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GITHUB_TOKEN")))
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity && bytes.Contains(respBody, []byte("already exists")) {
+		return fmt.Sprintf("https://github.com/%s/%s/pulls (already open for %s)", githubOrg, project.Name, head), nil
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("failed to open pull request, status code: %d %s", resp.StatusCode, respBody)
+	}
+
+	var pr pullRequest
+	if err := json.Unmarshal(respBody, &pr); err != nil {
+		return "", err
+	}
+	return pr.HTMLURL, nil
+}
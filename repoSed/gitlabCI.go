@@ -0,0 +1,1080 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	gitlabCIPath     = ".gitlab-ci.yml"
+	ciWorkflowPath   = ".github/workflows/gitlab-ci.yml"
+	ciWorkflowHeader = "Translated from .gitlab-ci.yml by repoSed."
+	ciTranslateRule  = "translate-gitlab-ci"
+)
+
+// gitlabKeywords are the top level .gitlab-ci.yml keys that are not jobs.
+var gitlabKeywords = map[string]bool{
+	"image": true, "services": true, "before_script": true, "after_script": true, "variables": true,
+	"cache": true, "stages": true, "default": true, "include": true, "workflow": true,
+}
+
+// ciVariables maps GitLab predefined variables to the closest GitHub Actions expression.
+var ciVariables = map[string]string{
+	"CI_COMMIT_SHA":      "github.sha",
+	"CI_COMMIT_REF_NAME": "github.ref_name",
+	"CI_COMMIT_REF_SLUG": "github.ref_name",
+	"CI_COMMIT_BRANCH":   "(github.ref_type == 'branch' && github.ref_name || '')",
+	"CI_COMMIT_TAG":      "(github.ref_type == 'tag' && github.ref_name || '')",
+	"CI_COMMIT_MESSAGE":  "github.event.head_commit.message",
+	"CI_DEFAULT_BRANCH":  "github.event.repository.default_branch",
+	"CI_PIPELINE_ID":     "github.run_id",
+	"CI_PIPELINE_SOURCE": "github.event_name",
+	"CI_JOB_NAME":        "github.job",
+	"CI_PROJECT_NAME":    "github.event.repository.name",
+	"CI_PROJECT_PATH":    "github.repository",
+	"CI_PROJECT_DIR":     "github.workspace",
+}
+
+// ciPipelineSources maps $CI_PIPELINE_SOURCE values to github.event_name values.
+var ciPipelineSources = map[string]string{
+	"push":                "push",
+	"merge_request_event": "pull_request",
+	"web":                 "workflow_dispatch",
+	"schedule":            "schedule",
+	"api":                 "repository_dispatch",
+	"trigger":             "repository_dispatch",
+}
+
+var (
+	ciVariableRef   = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?`)
+	ciSourceCompare = regexp.MustCompile(`\$\{?CI_PIPELINE_SOURCE\}?\s*(==|!=)\s*["']([^"']*)["']`)
+	ciStringLiteral = regexp.MustCompile(`"([^"]*)"`)
+	jobIDUnsafe     = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// ciJob is one GitLab job after extends and defaults have been applied.
+type ciJob struct {
+	name   string
+	id     string
+	stage  string
+	def    map[string]interface{}
+	cond   string
+	todos  []string
+	result *yaml.Node
+}
+
+// ciTranslator carries what translating one .gitlab-ci.yml learns about the whole workflow.
+type ciTranslator struct {
+	todos       []string
+	pullRequest bool
+	dispatch    bool
+	usedCIVars  map[string]bool
+}
+
+// translateCIFile writes a GitHub Actions workflow translated from the branch's .gitlab-ci.yml.
+// source is the current .gitlab-ci.yml text, so rewrites from earlier rules are included even in a dry run.
+// It returns nil when there is no .gitlab-ci.yml or the workflow is already up to date.
+func translateCIFile(logger *log.Logger, folder string, edits []*fileEdit, dryRun bool) (*fileEdit, error) {
+	source, err := os.ReadFile(filepath.Join(folder, gitlabCIPath))
+	if os.IsNotExist(err) {
+		logger.Printf("Info\tNo %s to translate", gitlabCIPath)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, edit := range edits {
+		if edit.name == gitlabCIPath {
+			source = []byte(edit.after)
+		}
+	}
+
+	fullpath := filepath.Join(folder, ciWorkflowPath)
+	existing, err := os.ReadFile(fullpath)
+	if err != nil {
+		existing = nil
+	}
+	edit, err := ciWorkflowEdit(logger, source, existing)
+	if err != nil || edit == nil || dryRun {
+		return edit, err
+	}
+	err = os.MkdirAll(filepath.Dir(fullpath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(fullpath, []byte(edit.after), 0644)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Info\tTranslated %s to %s", gitlabCIPath, ciWorkflowPath)
+	return edit, nil
+}
+
+// ciWorkflowEdit translates source into the workflow that should replace existing, nil when there is no workflow yet.
+// It returns nil when existing is up to date or was not written by repoSed.
+func ciWorkflowEdit(logger *log.Logger, source []byte, existing []byte) (*fileEdit, error) {
+	workflow, err := translateGitlabCI(source)
+	if err != nil {
+		return nil, fmt.Errorf("error translating %s: %v", gitlabCIPath, err)
+	}
+	edit := &fileEdit{name: ciWorkflowPath, after: string(workflow), matches: map[string]int{ciTranslateRule: 1}}
+	if existing != nil {
+		if !bytes.Contains(existing, []byte(ciWorkflowHeader)) {
+			logger.Printf("Info\tNot overwriting %s, it was not written by repoSed", ciWorkflowPath)
+			return nil, nil
+		}
+		edit.before = string(existing)
+	}
+	if edit.before == edit.after {
+		return nil, nil
+	}
+	return edit, nil
+}
+
+// translateGitlabCI turns a .gitlab-ci.yml into a GitHub Actions workflow.
+// Anything without a faithful equivalent is kept as close as possible and flagged with a TODO comment.
+func translateGitlabCI(source []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(source, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level is not a mapping")
+	}
+
+	// decode each top level key separately to keep the job order
+	order := []string{}
+	top := map[string]interface{}{}
+	for i := 0; i+1 < len(doc.Content[0].Content); i += 2 {
+		key := doc.Content[0].Content[i].Value
+		var value interface{}
+		if err := doc.Content[0].Content[i+1].Decode(&value); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		order = append(order, key)
+		top[key] = value
+	}
+
+	t := &ciTranslator{usedCIVars: map[string]bool{}}
+	if _, ok := top["include"]; ok {
+		t.todos = append(t.todos, "include: is not translated; copy the included jobs in by hand")
+	}
+	if _, ok := top["workflow"]; ok {
+		t.todos = append(t.todos, "workflow: rules are not translated; adjust the on: triggers by hand")
+	}
+
+	defaults := asMap(top["default"])
+	for _, key := range []string{"image", "services", "before_script", "after_script", "cache"} {
+		if value, ok := top[key]; ok {
+			if _, set := defaults[key]; !set {
+				defaults[key] = value
+			}
+		}
+	}
+
+	stages := asStrings(top["stages"])
+	if len(stages) == 0 {
+		stages = []string{"build", "test", "deploy"}
+	}
+	stages = append(append([]string{".pre"}, stages...), ".post")
+
+	jobs := []*ciJob{}
+	ids := map[string]string{}
+	for _, name := range order {
+		if gitlabKeywords[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if _, ok := top[name].(map[string]interface{}); !ok {
+			continue
+		}
+		def, todos := resolveExtends(top, name, 0)
+		inheritDefault := true
+		if inherit := asMap(def["inherit"]); inherit["default"] == false {
+			inheritDefault = false
+		}
+		if inheritDefault {
+			for key, value := range defaults {
+				if _, ok := def[key]; !ok {
+					def[key] = value
+				}
+			}
+		}
+		stage := asString(def["stage"])
+		if stage == "" {
+			stage = "test"
+		}
+		job := &ciJob{name: name, id: uniqueJobID(name, ids), stage: stage, def: def, todos: todos}
+		ids[name] = job.id
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		// the jobs are all in included files, most likely; leave a workflow that says so rather than failing the branch
+		t.todos = append(t.todos, "no jobs found in .gitlab-ci.yml; add them by hand")
+		return t.render(top, []*ciJob{stubJob()})
+	}
+
+	// conditions first: they decide which triggers the workflow needs
+	for _, job := range jobs {
+		job.cond = t.jobCondition(job)
+	}
+	for _, job := range jobs {
+		t.translateJob(job, jobs, stages, ids)
+	}
+	return t.render(top, jobs)
+}
+
+// resolveExtends merges a job over the jobs it extends, the way GitLab does: maps merge deeply, everything else is replaced.
+// Jobs it can't find, such as ones from an include, are left out and returned as TODOs.
+func resolveExtends(top map[string]interface{}, name string, depth int) (map[string]interface{}, []string) {
+	job := asMap(top[name])
+	merged := map[string]interface{}{}
+	todos := []string{}
+	for _, parent := range asStrings(job["extends"]) {
+		if _, ok := top[parent].(map[string]interface{}); !ok {
+			todos = append(todos, fmt.Sprintf("extends %s, which is not in this file; copy its keys in by hand", parent))
+			continue
+		}
+		if depth >= 10 {
+			todos = append(todos, fmt.Sprintf("extends nests too deeply at %s; copy its keys in by hand", parent))
+			continue
+		}
+		base, more := resolveExtends(top, parent, depth+1)
+		merged = deepMerge(merged, base)
+		todos = append(todos, more...)
+	}
+	merged = deepMerge(merged, job)
+	delete(merged, "extends")
+	return merged, todos
+}
+
+// stubJob stands in for the jobs of a .gitlab-ci.yml with none of its own, since a workflow needs at least one.
+func stubJob() *ciJob {
+	out := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(out, "runs-on", str("ubuntu-latest"))
+	step := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(step, "run", str("echo 'No jobs were translated from .gitlab-ci.yml' && exit 1"))
+	setKey(out, "steps", &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{step}})
+	return &ciJob{name: "todo", id: "todo", result: out, todos: []string{"replace this job with the pipeline's jobs"}}
+}
+
+func deepMerge(base map[string]interface{}, over map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for key, value := range base {
+		out[key] = value
+	}
+	for key, value := range over {
+		baseMap, baseIsMap := out[key].(map[string]interface{})
+		overMap, overIsMap := value.(map[string]interface{})
+		if baseIsMap && overIsMap {
+			out[key] = deepMerge(baseMap, overMap)
+			continue
+		}
+		out[key] = value
+	}
+	return out
+}
+
+func uniqueJobID(name string, ids map[string]string) string {
+	id := strings.Trim(jobIDUnsafe.ReplaceAllString(name, "-"), "-")
+	if id == "" || !(id[0] == '_' || (id[0] >= 'A' && id[0] <= 'Z') || (id[0] >= 'a' && id[0] <= 'z')) {
+		id = "job-" + id
+	}
+	taken := map[string]bool{}
+	for _, existing := range ids {
+		taken[existing] = true
+	}
+	candidate := id
+	for i := 2; taken[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d", id, i)
+	}
+	return candidate
+}
+
+// jobCondition builds the GitHub job `if:` from only/except, rules and when.
+// It returns "" when the job always runs.
+func (t *ciTranslator) jobCondition(job *ciJob) string {
+	conds := []string{}
+	if only, ok := job.def["only"]; ok {
+		if c := t.refsCondition(job, only, "only"); c != "" {
+			conds = append(conds, c)
+		}
+	}
+	if except, ok := job.def["except"]; ok {
+		if c := t.refsCondition(job, except, "except"); c != "" {
+			conds = append(conds, fmt.Sprintf("!(%s)", c))
+		}
+	}
+	if rules, ok := job.def["rules"].([]interface{}); ok {
+		if c := t.rulesCondition(job, rules); c != "" {
+			conds = append(conds, c)
+		}
+	}
+
+	switch when := asString(job.def["when"]); when {
+	case "manual":
+		t.dispatch = true
+		job.todos = append(job.todos, "was a manual job; it now only runs from a workflow_dispatch")
+		conds = append(conds, "github.event_name == 'workflow_dispatch'")
+	case "delayed":
+		job.todos = append(job.todos, fmt.Sprintf("was delayed by %s; GitHub has no delayed jobs", asString(job.def["start_in"])))
+	case "on_failure":
+		conds = append([]string{"failure()"}, conds...)
+	case "always":
+		conds = append([]string{"always()"}, conds...)
+	case "never":
+		conds = []string{"false"}
+	}
+	return joinConditions(conds, " && ")
+}
+
+// refsCondition translates only: or except: into an expression that is true when the refs match.
+func (t *ciTranslator) refsCondition(job *ciJob, value interface{}, keyword string) string {
+	refs := asStrings(value)
+	if spec, ok := value.(map[string]interface{}); ok {
+		refs = asStrings(spec["refs"])
+		for _, variable := range asStrings(spec["variables"]) {
+			if c, ok := t.expression(variable); ok {
+				refs = append(refs, "expr:"+c)
+			} else {
+				job.todos = append(job.todos, fmt.Sprintf("%s:variables %q is not translated", keyword, variable))
+			}
+		}
+		for _, key := range []string{"changes", "kubernetes"} {
+			if _, ok := spec[key]; ok {
+				job.todos = append(job.todos, fmt.Sprintf("%s:%s is not translated", keyword, key))
+			}
+		}
+	}
+
+	conds := []string{}
+	for _, ref := range refs {
+		switch {
+		case strings.HasPrefix(ref, "expr:"):
+			conds = append(conds, strings.TrimPrefix(ref, "expr:"))
+		case ref == "branches":
+			conds = append(conds, "github.ref_type == 'branch'")
+		case ref == "tags":
+			conds = append(conds, "github.ref_type == 'tag'")
+		case ref == "merge_requests":
+			t.pullRequest = true
+			conds = append(conds, "github.event_name == 'pull_request'")
+		case ref == "web":
+			t.dispatch = true
+			conds = append(conds, "github.event_name == 'workflow_dispatch'")
+		case ref == "pushes":
+			conds = append(conds, "github.event_name == 'push'")
+		case ref == "schedules":
+			conds = append(conds, "github.event_name == 'schedule'")
+			t.todos = append(t.todos, fmt.Sprintf("job %s ran on GitLab schedules; add a schedule: trigger with the cron from GitLab", job.name))
+		case ref == "api" || ref == "triggers" || ref == "pipelines" || ref == "external" || ref == "chat":
+			job.todos = append(job.todos, fmt.Sprintf("%s: %s has no GitHub equivalent", keyword, ref))
+		case strings.HasPrefix(ref, "/"):
+			if c, ok := refPattern(ref); ok {
+				conds = append(conds, c)
+			} else {
+				job.todos = append(job.todos, fmt.Sprintf("%s: regexp %s is not translated; GitHub expressions have no regexps", keyword, ref))
+			}
+		default:
+			conds = append(conds, fmt.Sprintf("github.ref_name == '%s'", ref))
+		}
+	}
+	return joinConditions(conds, " || ")
+}
+
+// refPattern translates the simple regexps /^name$/ and /^prefix.*/ that cover most only: patterns.
+func refPattern(ref string) (string, bool) {
+	pattern := strings.TrimSuffix(strings.TrimPrefix(ref, "/"), "/")
+	if !strings.HasPrefix(pattern, "^") {
+		return "", false
+	}
+	pattern = strings.TrimPrefix(pattern, "^")
+	prefix := false
+	for _, suffix := range []string{".*$", ".*", ".+$", ".+"} {
+		if strings.HasSuffix(pattern, suffix) {
+			pattern, prefix = strings.TrimSuffix(pattern, suffix), true
+			break
+		}
+	}
+	pattern = strings.TrimSuffix(pattern, "$")
+	// only escaped dots are allowed; anything else is a real regexp
+	if unescaped := strings.ReplaceAll(pattern, `\.`, ""); regexp.QuoteMeta(unescaped) != unescaped {
+		return "", false
+	}
+	literal := strings.ReplaceAll(pattern, `\.`, ".")
+	if prefix {
+		return fmt.Sprintf("startsWith(github.ref_name, '%s')", literal), true
+	}
+	return fmt.Sprintf("github.ref_name == '%s'", literal), true
+}
+
+// rulesCondition translates rules: exactly when they only use if and when:
+// the first matching rule wins, so each rule also requires that no earlier rule matched.
+func (t *ciTranslator) rulesCondition(job *ciJob, rules []interface{}) string {
+	disjuncts := []string{}
+	earlier := []string{}
+	for _, item := range rules {
+		r := asMap(item)
+		for _, key := range []string{"changes", "exists", "variables", "allow_failure", "start_in"} {
+			if _, ok := r[key]; ok {
+				job.todos = append(job.todos, fmt.Sprintf("rules:%s is not translated", key))
+			}
+		}
+		cond := "true"
+		if ifExpr, ok := r["if"]; ok {
+			c, ok := t.expression(asString(ifExpr))
+			if !ok {
+				job.todos = append(job.todos, fmt.Sprintf("rules:if %q is not translated", asString(ifExpr)))
+				continue
+			}
+			cond = c
+		}
+		when := asString(r["when"])
+		if when != "never" {
+			runs := wrap(cond)
+			if when == "manual" {
+				t.dispatch = true
+				job.todos = append(job.todos, fmt.Sprintf("rule %q was manual; it now runs from a workflow_dispatch", asString(r["if"])))
+				runs = fmt.Sprintf("%s && github.event_name == 'workflow_dispatch'", runs)
+			}
+			parts := []string{runs}
+			for _, e := range earlier {
+				parts = append(parts, "!"+e)
+			}
+			disjuncts = append(disjuncts, strings.Join(parts, " && "))
+		}
+		earlier = append(earlier, wrap(cond))
+		if cond == "true" {
+			// a rule without if always matches, so later rules can't be reached
+			break
+		}
+	}
+	if len(disjuncts) == 0 {
+		return "false"
+	}
+	return joinConditions(disjuncts, " || ")
+}
+
+// expression translates a GitLab CI variable expression into a GitHub expression.
+// Regexp matches (=~, !~) can't be translated.
+func (t *ciTranslator) expression(expr string) (string, bool) {
+	if strings.Contains(expr, "=~") || strings.Contains(expr, "!~") {
+		return "", false
+	}
+	ok := true
+	out := ciSourceCompare.ReplaceAllStringFunc(expr, func(m string) string {
+		parts := ciSourceCompare.FindStringSubmatch(m)
+		source, known := ciPipelineSources[parts[2]]
+		if !known {
+			ok = false
+		}
+		if source == "pull_request" {
+			t.pullRequest = true
+		}
+		if source == "workflow_dispatch" {
+			t.dispatch = true
+		}
+		return fmt.Sprintf("github.event_name %s '%s'", parts[1], source)
+	})
+	out = ciVariableRef.ReplaceAllStringFunc(out, func(m string) string {
+		name := ciVariableRef.FindStringSubmatch(m)[1]
+		if mapped, known := ciVariables[name]; known {
+			return mapped
+		}
+		if strings.HasPrefix(name, "CI_") || strings.HasPrefix(name, "GITLAB_") {
+			ok = false
+			return m
+		}
+		// project CI/CD variables become repository variables
+		return "vars." + name
+	})
+	out = ciStringLiteral.ReplaceAllString(out, "'$1'")
+	return out, ok
+}
+
+func wrap(cond string) string {
+	if cond == "true" || !strings.ContainsAny(cond, " ") {
+		return cond
+	}
+	return "(" + cond + ")"
+}
+
+func joinConditions(conds []string, op string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	wrapped := []string{}
+	for _, c := range conds {
+		wrapped = append(wrapped, wrap(c))
+	}
+	return strings.Join(wrapped, op)
+}
+
+// translateJob builds the GitHub job node for one GitLab job.
+func (t *ciTranslator) translateJob(job *ciJob, jobs []*ciJob, stages []string, ids map[string]string) {
+	def := job.def
+	out := &yaml.Node{Kind: yaml.MappingNode}
+	if job.id != job.name {
+		setKey(out, "name", str(job.name))
+	}
+
+	needs := t.jobNeeds(job, jobs, stages, ids)
+	if len(needs) > 0 {
+		setKey(out, "needs", strSeq(needs))
+	}
+	cond := job.cond
+	if cond == "" && t.pullRequest {
+		// GitLab only runs jobs without rules in branch pipelines, not merge request pipelines
+		cond = "github.event_name != 'pull_request'"
+	}
+	for _, need := range needs {
+		for _, other := range jobs {
+			if other.id == need && other.cond != "" && !strings.Contains(cond, "always()") && !strings.Contains(cond, "failure()") {
+				// GitLab drops jobs whose rules don't match; GitHub would skip everything that needs them
+				cond = joinConditions([]string{"!failure() && !cancelled()", cond}, " && ")
+				break
+			}
+		}
+		if strings.Contains(cond, "!cancelled()") {
+			break
+		}
+	}
+	if cond != "" {
+		setKey(out, "if", str(fmt.Sprintf("${{ %s }}", cond)))
+	}
+	setKey(out, "runs-on", str("ubuntu-latest"))
+	if tags := asStrings(def["tags"]); len(tags) > 0 {
+		job.todos = append(job.todos, fmt.Sprintf("GitLab runner tags %v have no equivalent; pick a runs-on label", tags))
+	}
+
+	image, hasImage := def["image"]
+	if hasImage {
+		setKey(out, "container", t.containerNode(job, image))
+	}
+	if services, ok := def["services"].([]interface{}); ok && len(services) > 0 {
+		if node := t.servicesNode(job, services); len(node.Content) > 0 {
+			setKey(out, "services", node)
+			if !hasImage {
+				job.todos = append(job.todos, "services are reached by name only from a container job; add ports or a container image")
+			}
+		}
+	}
+	if env, ok := def["environment"]; ok {
+		if spec, isMap := env.(map[string]interface{}); isMap {
+			envNode := &yaml.Node{Kind: yaml.MappingNode}
+			setKey(envNode, "name", str(t.substitute(asString(spec["name"]))))
+			if url := asString(spec["url"]); url != "" {
+				setKey(envNode, "url", str(t.substitute(url)))
+			}
+			setKey(out, "environment", envNode)
+		} else {
+			setKey(out, "environment", str(t.substitute(asString(env))))
+		}
+	}
+	if allow, ok := def["allow_failure"]; ok && allow != false {
+		setKey(out, "continue-on-error", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	}
+	if timeout := asString(def["timeout"]); timeout != "" {
+		if minutes, ok := parseDuration(timeout, 1); ok {
+			setKey(out, "timeout-minutes", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(minutes)})
+		} else {
+			job.todos = append(job.todos, fmt.Sprintf("timeout %q is not translated", timeout))
+		}
+	}
+	for _, key := range []string{"retry", "parallel", "trigger", "coverage", "resource_group", "interruptible", "release", "secrets", "dependencies_proxy"} {
+		if _, ok := def[key]; ok {
+			job.todos = append(job.todos, fmt.Sprintf("%s: is not translated", key))
+		}
+	}
+	if vars := asMap(def["variables"]); len(vars) > 0 {
+		setKey(out, "env", t.variablesNode(vars))
+	}
+	setKey(out, "steps", t.stepsNode(job, jobs, stages))
+	job.result = out
+}
+
+// jobNeeds lists the GitHub job IDs a job waits for: its GitLab needs, or else every job in the previous stage that has jobs.
+func (t *ciTranslator) jobNeeds(job *ciJob, jobs []*ciJob, stages []string, ids map[string]string) []string {
+	if needs, ok := job.def["needs"].([]interface{}); ok {
+		out := []string{}
+		for _, need := range needs {
+			name := asString(need)
+			if spec, isMap := need.(map[string]interface{}); isMap {
+				name = asString(spec["job"])
+				if _, crossProject := spec["project"]; crossProject {
+					job.todos = append(job.todos, "cross-project needs are not translated")
+					continue
+				}
+			}
+			if id, known := ids[name]; known {
+				out = append(out, id)
+			} else {
+				job.todos = append(job.todos, fmt.Sprintf("needs unknown job %q", name))
+			}
+		}
+		return out
+	}
+
+	stageIndex := indexOf(stages, job.stage)
+	if stageIndex < 0 {
+		job.todos = append(job.todos, fmt.Sprintf("stage %q is not listed in stages:", job.stage))
+		return nil
+	}
+	for i := stageIndex - 1; i >= 0; i-- {
+		previous := []string{}
+		for _, other := range jobs {
+			if other.stage == stages[i] {
+				previous = append(previous, other.id)
+			}
+		}
+		if len(previous) > 0 {
+			return previous
+		}
+	}
+	return nil
+}
+
+func (t *ciTranslator) containerNode(job *ciJob, image interface{}) *yaml.Node {
+	name := asString(image)
+	if spec, ok := image.(map[string]interface{}); ok {
+		name = asString(spec["name"])
+		if _, ok := spec["entrypoint"]; ok {
+			job.todos = append(job.todos, "image entrypoint is not translated")
+		}
+	}
+	return t.imageNode(job, name, nil)
+}
+
+// imageNode is a container or service with credentials added for the org's private Docker Hub images.
+func (t *ciTranslator) imageNode(job *ciJob, image string, env map[string]interface{}) *yaml.Node {
+	image = t.substitute(image)
+	if strings.Contains(image, "CI_REGISTRY") || strings.Contains(image, "libapps-admin.uncw.edu") {
+		job.todos = append(job.todos, fmt.Sprintf("image %s is on the GitLab registry; point it at Docker Hub", image))
+	}
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(node, "image", str(image))
+	if strings.HasPrefix(image, githubOrg+"/") {
+		creds := &yaml.Node{Kind: yaml.MappingNode}
+		setKey(creds, "username", str("${{ secrets.DOCKERHUB_USER }}"))
+		setKey(creds, "password", str("${{ secrets.DOCKERHUB_TOKEN }}"))
+		setKey(node, "credentials", creds)
+	}
+	if len(env) > 0 {
+		setKey(node, "env", t.variablesNode(env))
+	}
+	return node
+}
+
+func (t *ciTranslator) servicesNode(job *ciJob, services []interface{}) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	taken := map[string]bool{}
+	for _, service := range services {
+		image := asString(service)
+		alias := ""
+		var env map[string]interface{}
+		if spec, ok := service.(map[string]interface{}); ok {
+			image, alias = asString(spec["name"]), asString(spec["alias"])
+			env = asMap(spec["variables"])
+			for _, key := range []string{"command", "entrypoint"} {
+				if _, ok := spec[key]; ok {
+					job.todos = append(job.todos, fmt.Sprintf("service %s %s is not translated", image, key))
+				}
+			}
+		}
+		if strings.HasPrefix(image, "docker:") && strings.Contains(image, "dind") {
+			job.todos = append(job.todos, "dropped the docker:dind service; GitHub runners have Docker, but a container job can't reach it without mounting the socket")
+			continue
+		}
+		if alias == "" {
+			// GitLab's default hostname is the image name without registry path or tag
+			alias = path.Base(strings.SplitN(image, ":", 2)[0])
+		}
+		alias = strings.Trim(jobIDUnsafe.ReplaceAllString(alias, "-"), "-")
+		for base, i := alias, 2; taken[alias]; i++ {
+			alias = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[alias] = true
+		setKey(node, alias, t.imageNode(job, image, env))
+	}
+	return node
+}
+
+func (t *ciTranslator) variablesNode(vars map[string]interface{}) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range sortedKeys(vars) {
+		value := vars[name]
+		if spec, ok := value.(map[string]interface{}); ok {
+			value = spec["value"]
+		}
+		setKey(node, name, str(t.substitute(asString(value))))
+	}
+	return node
+}
+
+// substitute replaces predefined GitLab variables in a value with GitHub expressions.
+func (t *ciTranslator) substitute(value string) string {
+	return ciVariableRef.ReplaceAllStringFunc(value, func(m string) string {
+		name := ciVariableRef.FindStringSubmatch(m)[1]
+		if mapped, ok := ciVariables[name]; ok {
+			return fmt.Sprintf("${{ %s }}", mapped)
+		}
+		return m
+	})
+}
+
+func (t *ciTranslator) stepsNode(job *ciJob, jobs []*ciJob, stages []string) *yaml.Node {
+	def := job.def
+	steps := &yaml.Node{Kind: yaml.SequenceNode}
+	checkout := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(checkout, "uses", str("actions/checkout@v4"))
+	steps.Content = append(steps.Content, checkout)
+
+	if cache, ok := def["cache"]; ok {
+		caches, isList := cache.([]interface{})
+		if !isList {
+			caches = []interface{}{cache}
+		}
+		for _, c := range caches {
+			if step := t.cacheStep(job, asMap(c)); step != nil {
+				steps.Content = append(steps.Content, step)
+			}
+		}
+	}
+
+	for _, dep := range t.artifactSources(job, jobs, stages) {
+		step := &yaml.Node{Kind: yaml.MappingNode}
+		setKey(step, "uses", str("actions/download-artifact@v4"))
+		with := &yaml.Node{Kind: yaml.MappingNode}
+		setKey(with, "name", str(dep.id))
+		setKey(with, "path", str(artifactRoot(asStrings(asMap(dep.def["artifacts"])["paths"]))))
+		setKey(step, "with", with)
+		steps.Content = append(steps.Content, step)
+	}
+
+	for _, part := range []string{"before_script", "script", "after_script"} {
+		lines := asStrings(def[part])
+		if len(lines) == 0 {
+			continue
+		}
+		for _, line := range lines {
+			for _, m := range ciVariableRef.FindAllStringSubmatch(line, -1) {
+				if strings.HasPrefix(m[1], "CI_") {
+					t.usedCIVars[m[1]] = true
+				}
+			}
+		}
+		step := &yaml.Node{Kind: yaml.MappingNode}
+		setKey(step, "name", str(part))
+		if part == "after_script" {
+			setKey(step, "if", str("${{ always() }}"))
+		}
+		setKey(step, "run", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: strings.Join(lines, "\n") + "\n"})
+		steps.Content = append(steps.Content, step)
+	}
+	if _, hasScript := def["script"]; !hasScript {
+		job.todos = append(job.todos, "job has no script")
+	}
+
+	if artifacts := asMap(def["artifacts"]); len(artifacts) > 0 {
+		if step := t.uploadStep(job, artifacts); step != nil {
+			steps.Content = append(steps.Content, step)
+		}
+	}
+	return steps
+}
+
+func (t *ciTranslator) cacheStep(job *ciJob, cache map[string]interface{}) *yaml.Node {
+	paths := asStrings(cache["paths"])
+	if len(paths) == 0 {
+		return nil
+	}
+	key := "gitlab-ci-default"
+	switch k := cache["key"].(type) {
+	case string:
+		key = t.substitute(k)
+	case map[string]interface{}:
+		files := []string{}
+		for _, f := range asStrings(k["files"]) {
+			files = append(files, fmt.Sprintf("'%s'", f))
+		}
+		key = fmt.Sprintf("${{ hashFiles(%s) }}", strings.Join(files, ", "))
+		if prefix := asString(k["prefix"]); prefix != "" {
+			key = t.substitute(prefix) + "-" + key
+		}
+	}
+	if policy := asString(cache["policy"]); policy == "pull" || policy == "push" {
+		job.todos = append(job.todos, fmt.Sprintf("cache policy %s is not translated; use actions/cache/restore or actions/cache/save", policy))
+	}
+	step := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(step, "uses", str("actions/cache@v4"))
+	with := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(with, "path", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: strings.Join(paths, "\n") + "\n"})
+	setKey(with, "key", str(key))
+	setKey(step, "with", with)
+	return step
+}
+
+func (t *ciTranslator) uploadStep(job *ciJob, artifacts map[string]interface{}) *yaml.Node {
+	if _, ok := artifacts["reports"]; ok {
+		job.todos = append(job.todos, "artifacts:reports are not translated")
+	}
+	paths := asStrings(artifacts["paths"])
+	if len(paths) == 0 {
+		return nil
+	}
+	step := &yaml.Node{Kind: yaml.MappingNode}
+	switch asString(artifacts["when"]) {
+	case "always":
+		setKey(step, "if", str("${{ always() }}"))
+	case "on_failure":
+		setKey(step, "if", str("${{ failure() }}"))
+	}
+	setKey(step, "uses", str("actions/upload-artifact@v4"))
+	with := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(with, "name", str(job.id))
+	setKey(with, "path", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: strings.Join(paths, "\n") + "\n"})
+	if expire := asString(artifacts["expire_in"]); expire != "" {
+		if days, ok := parseDuration(expire, 60*24); ok {
+			setKey(with, "retention-days", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(days)})
+		} else {
+			job.todos = append(job.todos, fmt.Sprintf("artifacts expire_in %q is not translated", expire))
+		}
+	}
+	setKey(step, "with", with)
+	return step
+}
+
+// artifactSources lists the jobs whose artifacts GitLab would hand this job:
+// its dependencies if set, else its needs, else every job in an earlier stage.
+func (t *ciTranslator) artifactSources(job *ciJob, jobs []*ciJob, stages []string) []*ciJob {
+	hasArtifacts := func(j *ciJob) bool { return len(asStrings(asMap(j.def["artifacts"])["paths"])) > 0 }
+	byName := map[string]*ciJob{}
+	for _, j := range jobs {
+		byName[j.name] = j
+	}
+	names := []string{}
+	if deps, ok := job.def["dependencies"]; ok {
+		names = asStrings(deps)
+	} else if needs, ok := job.def["needs"].([]interface{}); ok {
+		for _, need := range needs {
+			if spec, isMap := need.(map[string]interface{}); isMap {
+				if spec["artifacts"] != false {
+					names = append(names, asString(spec["job"]))
+				}
+				continue
+			}
+			names = append(names, asString(need))
+		}
+	} else {
+		stageIndex := indexOf(stages, job.stage)
+		for _, other := range jobs {
+			if i := indexOf(stages, other.stage); i >= 0 && i < stageIndex {
+				names = append(names, other.name)
+			}
+		}
+	}
+
+	sources := []*ciJob{}
+	for _, name := range names {
+		if other, ok := byName[name]; ok && hasArtifacts(other) {
+			sources = append(sources, other)
+		}
+	}
+	if len(sources) > 0 {
+		job.todos = append(job.todos, "check the artifact download paths; upload-artifact drops the paths' common leading directory")
+	}
+	return sources
+}
+
+// artifactRoot is where to download an artifact so its files land where GitLab would have put them.
+func artifactRoot(paths []string) string {
+	if len(paths) != 1 || strings.ContainsAny(paths[0], "*?[") {
+		return "."
+	}
+	p := strings.TrimSuffix(paths[0], "/")
+	if strings.HasSuffix(paths[0], "/") || !strings.Contains(path.Base(p), ".") {
+		return p
+	}
+	return path.Dir(p)
+}
+
+// parseDuration converts GitLab durations like "1 week", "2h 30m" or "45 minutes" into whole units of unitMinutes, rounding up.
+func parseDuration(value string, unitMinutes int) (int, bool) {
+	minutesPer := map[string]int{
+		"s": 0, "sec": 0, "secs": 0, "second": 0, "seconds": 0,
+		"m": 1, "min": 1, "mins": 1, "minute": 1, "minutes": 1,
+		"h": 60, "hr": 60, "hrs": 60, "hour": 60, "hours": 60,
+		"d": 60 * 24, "day": 60 * 24, "days": 60 * 24,
+		"w": 60 * 24 * 7, "wk": 60 * 24 * 7, "week": 60 * 24 * 7, "weeks": 60 * 24 * 7,
+		"mo": 60 * 24 * 30, "month": 60 * 24 * 30, "months": 60 * 24 * 30,
+		"y": 60 * 24 * 365, "yr": 60 * 24 * 365, "year": 60 * 24 * 365, "years": 60 * 24 * 365,
+	}
+	parts := regexp.MustCompile(`(\d+)\s*([a-z]+)`).FindAllStringSubmatch(strings.ToLower(value), -1)
+	if len(parts) == 0 {
+		return 0, false
+	}
+	total := 0
+	for _, part := range parts {
+		n, _ := strconv.Atoi(part[1])
+		per, ok := minutesPer[part[2]]
+		if !ok {
+			return 0, false
+		}
+		total += n * per
+	}
+	units := (total + unitMinutes - 1) / unitMinutes
+	if units < 1 {
+		units = 1
+	}
+	return units, true
+}
+
+// render assembles the workflow document.
+func (t *ciTranslator) render(top map[string]interface{}, jobs []*ciJob) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	header := []string{ciWorkflowHeader, "Review the TODO comments before relying on it."}
+	for _, todo := range t.todos {
+		header = append(header, "TODO: "+todo)
+	}
+	root.HeadComment = strings.Join(header, "\n")
+	setKey(root, "name", str("CI"))
+
+	on := &yaml.Node{Kind: yaml.MappingNode}
+	push := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(push, "branches", strSeq([]string{"**"}))
+	setKey(push, "tags", strSeq([]string{"**"}))
+	setKey(on, "push", push)
+	if t.pullRequest {
+		setKey(on, "pull_request", &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle})
+	}
+	if t.dispatch {
+		setKey(on, "workflow_dispatch", &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle})
+	}
+	setKey(root, "on", on)
+
+	env := &yaml.Node{Kind: yaml.MappingNode}
+	if vars := asMap(top["variables"]); len(vars) > 0 {
+		env = t.variablesNode(vars)
+	}
+	unknown := []string{}
+	for _, name := range sortedKeys(t.usedCIVars) {
+		if mapped, ok := ciVariables[name]; ok {
+			setKey(env, name, str(fmt.Sprintf("${{ %s }}", mapped)))
+		} else {
+			unknown = append(unknown, "$"+name)
+		}
+	}
+	if len(unknown) > 0 {
+		env.HeadComment = fmt.Sprintf("TODO: scripts use GitLab variables with no GitHub equivalent: %s", strings.Join(unknown, ", "))
+	}
+	if len(env.Content) > 0 || env.HeadComment != "" {
+		setKey(root, "env", env)
+		root.Content[len(root.Content)-2].HeadComment, env.HeadComment = env.HeadComment, ""
+	}
+
+	jobsNode := &yaml.Node{Kind: yaml.MappingNode}
+	for _, job := range jobs {
+		setKey(jobsNode, job.id, job.result)
+		if len(job.todos) > 0 {
+			lines := []string{}
+			for _, todo := range job.todos {
+				lines = append(lines, "TODO: "+todo)
+			}
+			jobsNode.Content[len(jobsNode.Content)-2].HeadComment = strings.Join(lines, "\n")
+		}
+	}
+	setKey(root, "jobs", jobsNode)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func setKey(m *yaml.Node, key string, value *yaml.Node) {
+	m.Content = append(m.Content, str(key), value)
+}
+
+func str(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+func strSeq(items []string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, item := range items {
+		node.Content = append(node.Content, str(item))
+	}
+	return node
+}
+
+func asString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// asStrings flattens a string or a (possibly nested, via anchors) list into strings.
+func asStrings(v interface{}) []string {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		out := []string{}
+		for _, item := range value {
+			out = append(out, asStrings(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		return nil
+	default:
+		return []string{asString(value)}
+	}
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func indexOf(items []string, item string) int {
+	for i, candidate := range items {
+		if candidate == item {
+			return i
+		}
+	}
+	return -1
+}
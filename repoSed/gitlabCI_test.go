@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTranslateGitlabCI(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "stages",
+			source: "stages: [build, test]\nbuild:\n  stage: build\n  script: [make]\ntest:\n  stage: test\n  script: [make test]\n",
+			want:   []string{"  test:\n    needs:\n      - build\n", "        run: |\n          make test\n"},
+		},
+		{
+			name:   "only and except",
+			source: "deploy:\n  only: [main, tags, merge_requests]\n  except: [/^wip-.*$/]\n  script: [./deploy]\n",
+			want: []string{
+				"  pull_request: {}\n",
+				"if: ${{ ((github.ref_name == 'main') || (github.ref_type == 'tag') || (github.event_name == 'pull_request')) && (!(startsWith(github.ref_name, 'wip-'))) }}",
+			},
+		},
+		{
+			name:   "only regexp",
+			source: "deploy:\n  only: ['/^release-[0-9]+$/']\n  script: [./deploy]\n",
+			want:   []string{"# TODO: only: regexp /^release-[0-9]+$/ is not translated"},
+		},
+		{
+			name:   "rules",
+			source: "deploy:\n  rules:\n    - if: '$CI_COMMIT_BRANCH == \"main\"'\n    - if: '$DEPLOY == \"yes\"'\n      when: manual\n    - when: never\n  script: [./deploy]\n",
+			want: []string{
+				"  workflow_dispatch: {}\n",
+				"if: ${{ (((github.ref_type == 'branch' && github.ref_name || '') == 'main')) || ((vars.DEPLOY == 'yes') && github.event_name == 'workflow_dispatch' && !((github.ref_type == 'branch' && github.ref_name || '') == 'main')) }}",
+				"# TODO: rule \"$DEPLOY == \\\"yes\\\"\" was manual",
+			},
+		},
+		{
+			name:   "rules with changes",
+			source: "test:\n  rules:\n    - changes: [src/**]\n  script: [make]\n",
+			want:   []string{"# TODO: rules:changes is not translated"},
+		},
+		{
+			name:   "extends",
+			source: ".base:\n  image: node:20\n  variables:\n    A: \"1\"\n  script: [npm ci]\ntest:\n  extends: .base\n  variables:\n    B: \"2\"\n",
+			want:   []string{"    container:\n      image: node:20\n", "    env:\n      A: \"1\"\n      B: \"2\"\n", "          npm ci\n"},
+		},
+		{
+			name:   "extends from an include",
+			source: "include: [{project: group/ci, file: /node.yml}]\nlint:\n  extends: .node\n  script: [npm run lint]\n",
+			want: []string{
+				"# TODO: include: is not translated",
+				"  # TODO: extends .node, which is not in this file; copy its keys in by hand\n  lint:\n",
+				"          npm run lint\n",
+			},
+		},
+		{
+			name:   "extends loop",
+			source: "a:\n  extends: b\n  script: [a]\nb:\n  extends: a\n  script: [b]\n",
+			want:   []string{"# TODO: extends nests too deeply"},
+		},
+		{
+			name:   "include only",
+			source: "include:\n  - project: group/ci\n    file: /templates/node.yml\n",
+			want: []string{
+				"# TODO: include: is not translated",
+				"# TODO: no jobs found in .gitlab-ci.yml",
+				"  # TODO: replace this job with the pipeline's jobs\n  todo:\n",
+				"exit 1",
+			},
+		},
+		{
+			name:   "services",
+			source: "test:\n  image: python:3.12\n  services: [postgres:16, {name: redis:7, alias: cache}]\n  script: [pytest]\n",
+			want:   []string{"    services:\n      postgres:\n        image: postgres:16\n      cache:\n        image: redis:7\n"},
+		},
+		{
+			name:   "services without an image",
+			source: "test:\n  services: [postgres:16]\n  script: [pytest]\n",
+			want:   []string{"# TODO: services are reached by name only from a container job"},
+		},
+		{
+			name:   "artifacts",
+			source: "stages: [build, test]\nbuild:\n  stage: build\n  script: [make]\n  artifacts:\n    paths: [dist/]\n    expire_in: 1 week\ntest:\n  stage: test\n  script: [make test]\n",
+			want: []string{
+				"      - uses: actions/upload-artifact@v4\n        with:\n          name: build\n          path: |\n            dist/\n          retention-days: 7\n",
+				"      - uses: actions/download-artifact@v4\n        with:\n          name: build\n",
+			},
+		},
+		{
+			name:   "cache",
+			source: "cache:\n  key: deps\n  paths: [node_modules/]\nbuild:\n  script: [npm ci]\n",
+			want:   []string{"      - uses: actions/cache@v4\n        with:\n          path: |\n            node_modules/\n          key: deps\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := translateGitlabCI([]byte(tc.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var workflow map[string]interface{}
+			if err := yaml.Unmarshal(out, &workflow); err != nil || len(asMap(workflow["jobs"])) == 0 {
+				t.Fatalf("not a workflow with jobs (%v):\n%s", err, out)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("missing %q in:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateGitlabCIRejects(t *testing.T) {
+	for _, source := range []string{"- a\n- b\n", "test: [\n"} {
+		if _, err := translateGitlabCI([]byte(source)); err == nil {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExcludes are directory names never walked when looking for files to edit.
+var defaultExcludes = []string{".git", "node_modules", "vendor"}
+
+// matchGlob reports whether the slash separated name matches pattern.
+// Segments are matched with path.Match, and a "**" segment matches zero or more directories.
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(patterns []string, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			// collapse repeated ** and try every possible split point
+			for len(patterns) > 0 && patterns[0] == "**" {
+				patterns = patterns[1:]
+			}
+			if len(patterns) == 0 {
+				return true
+			}
+			for i := range names {
+				if matchSegments(patterns, names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		ok, err := path.Match(patterns[0], names[0])
+		if err != nil || !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// listFiles walks root and returns every regular file as a slash separated path relative to root.
+// Directories named in defaultExcludes, and paths matching an exclude glob, are skipped.
+func listFiles(root string, excludes []string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			for _, name := range defaultExcludes {
+				if d.Name() == name {
+					return filepath.SkipDir
+				}
+			}
+		}
+		for _, pattern := range excludes {
+			if matchGlob(pattern, rel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if d.Type().IsRegular() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// matchesAny reports whether name matches any of the patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// targets reports whether any of the rule's file patterns match name.
+func (r rule) targets(name string) bool {
+	return matchesAny(r.Files, name)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.md", "README.md", true},
+		{"*.md", "docs/README.md", false},
+		{"docs/*.md", "docs/README.md", true},
+		{"docs/?.md", "docs/a.md", true},
+		{"docs/[ab].md", "docs/c.md", false},
+		// a leading ** matches zero or more directories
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/README.md", true},
+		{"**/*.md", "README.txt", false},
+		// ** in the middle may match no directory at all
+		{"docs/**/*.md", "docs/README.md", true},
+		{"docs/**/*.md", "docs/a/b/README.md", true},
+		{"docs/**/*.md", "other/README.md", false},
+		{"a/**/**/b", "a/b", true},
+		{"a/**/b", "a/xb", false},
+		// a trailing ** matches everything below, and the directory itself
+		{"docs/**", "docs/a/b.md", true},
+		{"docs/**", "docs", true},
+		{"docs/**", "docsx/a.md", false},
+		{"**", "any/depth/at/all", true},
+		{"**/vendor/**", "vendor", true},
+		{"**/vendor/**", "web/vendor/lib/a.js", true},
+		{"**/vendor/**", "web/vendors/a.js", false},
+		{"docker-compose*.yml", "docker-compose.prod.yml", true},
+		{"docs/[", "docs/[", false},
+	} {
+		if got := matchGlob(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		".git/config",
+		".gitlab-ci.yml",
+		"node_modules/left-pad/index.js",
+		"web/node_modules/x/index.js",
+		"vendor/lib/lib.go",
+		"src/vendor/lib.go",
+		"src/main.go",
+		"src/app.min.js",
+		"build/out/app.js",
+		"notes/vendor",
+	} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("src/main.go", filepath.Join(root, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := listFiles(root, []string{"build/**", "**/*.min.js"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// .git, node_modules and vendor directories are skipped at any depth, but a file named vendor is not
+	want := []string{".gitlab-ci.yml", "notes/vendor", "src/main.go"}
+	if !slices.Equal(files, want) {
+		t.Errorf("listFiles = %v, want %v", files, want)
+	}
+}
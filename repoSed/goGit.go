@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// goGit runs git operations in process with go-git.
+// It returns errUnsupported for what go-git can't do the way git would, such as signing commits.
+type goGit struct{}
+
+// goGitAuth picks the token for url's host from the environment, as the git binary would from its credential helper.
+func goGitAuth(url string) transport.AuthMethod {
+	switch {
+	case !strings.HasPrefix(url, "http"):
+		return nil
+	case strings.Contains(url, "://github.com/") && os.Getenv("GITHUB_TOKEN") != "":
+		return &http.BasicAuth{Username: "x-access-token", Password: os.Getenv("GITHUB_TOKEN")}
+	case strings.Contains(url, "://"+libappsHost) && os.Getenv("LIBAPPS_ADMIN_TOKEN") != "":
+		return &http.BasicAuth{Username: "oauth2", Password: os.Getenv("LIBAPPS_ADMIN_TOKEN")}
+	}
+	return nil
+}
+
+// open opens the repository at folder; branch worktrees keep most of their git directory in the main clone.
+func (goGit) open(folder string) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(folder, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+}
+
+func (goGit) clone(logger *log.Logger, url string, folder string) error {
+	if nonEmptyDir(folder) {
+		return errAlreadyCloned
+	}
+	logger.Printf("Running\tin process: clone %s %s", url, folder)
+	_, err := git.PlainClone(folder, false, &git.CloneOptions{URL: url, Auth: goGitAuth(url)})
+	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		return errAlreadyCloned
+	}
+	return err
+}
+
+func (g goGit) fetchPull(logger *log.Logger, folder string) error {
+	logger.Printf("Running\tin process: fetch and fast-forward in %s", folder)
+	r, err := g.open(folder)
+	if err != nil {
+		return err
+	}
+	remotes, err := r.Remotes()
+	if err != nil {
+		return err
+	}
+	for _, remote := range remotes {
+		err = remote.Fetch(&git.FetchOptions{Auth: goGitAuth(remote.Config().URLs[0])})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return fmt.Errorf("fetching %s: %v", remote.Config().Name, err)
+		}
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return nil
+	}
+	upstream, err := r.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if upstream.Hash() == head.Hash() {
+		return nil
+	}
+	local, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	remote, err := r.CommitObject(upstream.Hash())
+	if err != nil {
+		return err
+	}
+	if ahead, err := remote.IsAncestor(local); err != nil || ahead {
+		// local commits not pushed yet, nothing to pull
+		return err
+	}
+	if behind, err := local.IsAncestor(remote); err != nil || !behind {
+		if err != nil {
+			return err
+		}
+		return errNotFastForward
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&git.ResetOptions{Commit: upstream.Hash(), Mode: git.MergeReset})
+}
+
+func (g goGit) checkout(logger *log.Logger, folder string, branch string) error {
+	logger.Printf("Running\tin process: checkout %s in %s", branch, folder)
+	r, err := g.open(folder)
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	local := plumbing.NewBranchReferenceName(branch)
+	if _, err := r.Reference(local, false); err == nil {
+		return wt.Checkout(&git.CheckoutOptions{Branch: local})
+	}
+	upstream, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		return fmt.Errorf("no branch %s locally or on origin: %v", branch, err)
+	}
+	err = wt.Checkout(&git.CheckoutOptions{Branch: local, Hash: upstream.Hash(), Create: true})
+	if err != nil {
+		return err
+	}
+	return r.CreateBranch(&config.Branch{Name: branch, Remote: "origin", Merge: local})
+}
+
+func (g goGit) clean(logger *log.Logger, folder string) (bool, error) {
+	r, err := g.open(folder)
+	if err != nil {
+		return false, err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return false, err
+	}
+	return status.IsClean(), nil
+}
+
+func (g goGit) commitAll(logger *log.Logger, folder string, message string, opts *options) (string, error) {
+	if opts.sign != "" {
+		return "", fmt.Errorf("%w: %s signed commits", errUnsupported, opts.sign)
+	}
+	attr, err := conversionAttribute(folder)
+	if err != nil {
+		return "", err
+	}
+	if attr != "" {
+		// go-git stages files as they are on disk, so LFS and other filters would commit their smudged content
+		return "", fmt.Errorf("%w: committing with %s in .gitattributes", errUnsupported, attr)
+	}
+	logger.Printf("Running\tin process: commit in %s", folder)
+	r, err := g.open(folder)
+	if err != nil {
+		return "", err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+	err = wt.AddWithOptions(&git.AddOptions{All: true})
+	if err != nil {
+		return "", err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return "", err
+	}
+	if status.IsClean() {
+		return "", errNothingToCommit
+	}
+	commitOpts := &git.CommitOptions{}
+	if opts.commitName != "" {
+		// without an identity go-git reads user.name and user.email from git config, like git does
+		sig := &object.Signature{Name: opts.commitName, Email: opts.commitEmail, When: time.Now()}
+		commitOpts.Author, commitOpts.Committer = sig, sig
+	}
+	hash, err := wt.Commit(message, commitOpts)
+	if errors.Is(err, git.ErrMissingAuthor) {
+		// git also takes the identity from GIT_AUTHOR_* variables and other places go-git doesn't look
+		return "", fmt.Errorf("%w: no user.name and user.email in git config", errUnsupported)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// conversionAttribute returns the first filter, eol or text attribute in the worktree's .gitattributes files,
+// which git applies when staging files and go-git doesn't, or "" if there are none.
+func conversionAttribute(folder string) (string, error) {
+	attr := ""
+	err := filepath.WalkDir(folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() != ".gitattributes" {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, a := range fields[1:] {
+				if strings.HasPrefix(a, "filter=") || strings.HasPrefix(a, "eol=") || a == "text" || strings.HasPrefix(a, "text=") {
+					attr = a
+					return filepath.SkipAll
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading .gitattributes in %s, %v", folder, err)
+	}
+	return attr, nil
+}
+
+func (g goGit) head(logger *log.Logger, folder string) (string, error) {
+	r, err := g.open(folder)
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+func (g goGit) remoteBranch(logger *log.Logger, folder string, remote string, branch string) (string, error) {
+	r, err := g.open(folder)
+	if err != nil {
+		return "", err
+	}
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+func (g goGit) push(logger *log.Logger, folder string, remote string, branch string, expected string) error {
+	logger.Printf("Running\tin process: push %s to %s in %s", branch, remote, folder)
+	r, err := g.open(folder)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return fmt.Errorf("%w: pushing a detached HEAD", errUnsupported)
+	}
+	rem, err := r.Remote(remote)
+	if err != nil {
+		return err
+	}
+	auth := goGitAuth(rem.Config().URLs[0])
+	dst := plumbing.NewBranchReferenceName(branch)
+	pushOpts := &git.PushOptions{RemoteName: remote, Auth: auth, RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", head.Name(), dst))}}
+	if expected != "" {
+		pushOpts.ForceWithLease = &git.ForceWithLease{RefName: dst, Hash: plumbing.NewHash(expected)}
+	} else {
+		// go-git's lease needs a remote-tracking ref, so check by hand that the branch is still new
+		refs, err := rem.List(&git.ListOptions{Auth: auth})
+		if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return err
+		}
+		for _, ref := range refs {
+			if ref.Name() == dst {
+				return fmt.Errorf("%w: %s already exists on %s", errPushRejected, branch, remote)
+			}
+		}
+	}
+	err = r.Push(pushOpts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("%w: %v", errPushRejected, err)
+	}
+	// keep the remote-tracking branch current, as git push does
+	return r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName(remote, branch), head.Hash()))
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// historySuffix names the fresh mirror clone a history rewrite works in, beside the project's normal clone.
+const historySuffix = ".history.git"
+
+// historyBranch stands in for the branch name in the report, since a history rewrite covers every ref at once.
+const historyBranch = "(all history)"
+
+// historyRewriter filters a git fast-export stream into git fast-import,
+// replacing each blob the rules target with its rewritten content.
+type historyRewriter struct {
+	logger  *log.Logger
+	rules   []rule
+	maxSize int64
+
+	catIn  io.WriteCloser
+	catOut *bufio.Reader
+
+	// blobs caches each blob's rewritten content, nil when unchanged, so a blob shared by many commits is read once
+	blobs     map[string][]byte
+	originals []string
+	marks     map[string]string
+	files     map[string]map[string]int
+	skipped   map[string]string
+}
+
+// destinationURL is where remote ("origin" or "github") points for the project.
+// With -github-remote set, "github" is <github-remote>/<project>.git instead of the project's GitHub repo.
+func destinationURL(project Project, remote string, opts *options) string {
+	if remote != "github" {
+		return project.URL
+	}
+	if opts.githubRemote != "" {
+		return fmt.Sprintf("%s/%s.git", strings.TrimSuffix(opts.githubRemote, "/"), project.Name)
+	}
+	return githubRepoURL(project.Name)
+}
+
+// historyFolder rewrites every commit and tag of the project in a fresh mirror clone, writes the old to new SHA map
+// and pushes the result. It refuses to run unless the mirror is new and the destination has no refs yet,
+// since rewritten history can't be pushed over the old one.
+func historyFolder(logger *log.Logger, folder string, project Project, opts *options) error {
+	logger.Printf("Starting\thistory rewrite of %s", project.Name)
+	mirror := folder + historySuffix
+	if _, err := os.Stat(mirror); err == nil {
+		return fmt.Errorf("%s already exists; history rewrites only run in a fresh mirror, remove it first", mirror)
+	}
+	dest := destinationURL(project, opts.pushRemote, opts)
+	refs, err := runCommand(logger, ".", "git", "ls-remote", dest)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error listing refs at %s", dest)
+	}
+	if refs != "" {
+		return fmt.Errorf("refusing to rewrite history: %s already has refs, push rewritten history only to a fresh, empty mirror", dest)
+	}
+	_, err = runCommand(logger, ".", "git", "clone", "--mirror", project.URL, mirror)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error mirroring repository %s", project.URL)
+	}
+
+	if opts.scanSecrets {
+		findings, err := scanHistory(logger, mirror, opts.secretAllow)
+		if err != nil {
+			return err
+		}
+		opts.secrets.record(project.Name, findings)
+		opts.report.recordSecrets(project, len(findings))
+		if len(findings) > 0 {
+			opts.pushes.record(project, historyBranch, opts.pushRemote, "", fmt.Sprintf("blocked: %d secret findings", len(findings)))
+			return fmt.Errorf("not rewriting or pushing %s: %d secret findings", project.Name, len(findings))
+		}
+	}
+
+	h := &historyRewriter{
+		logger:  logger,
+		rules:   opts.rules,
+		maxSize: opts.maxFileSize,
+		blobs:   map[string][]byte{},
+		marks:   map[string]string{},
+		files:   map[string]map[string]int{},
+		skipped: map[string]string{},
+	}
+	shaMap, err := h.run(mirror)
+	if err != nil {
+		return err
+	}
+	mapPath := filepath.Join(opts.projectLogDir, projectKey(project)+".sha-map")
+	err = writeSHAMap(mapPath, shaMap)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Info\tWrote %d rewritten SHAs to %s", len(shaMap), mapPath)
+
+	_, err = runCommand(logger, mirror, "git", "push", dest, "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		opts.pushes.record(project, historyBranch, opts.pushRemote, "", "failed")
+		return fmt.Errorf("error pushing rewritten history to %s", dest)
+	}
+	heads, err := runCommand(logger, mirror, "git", "for-each-ref", "--format=%(refname:short) %(objectname)", "refs/heads")
+	if err != nil {
+		return fmt.Errorf("error listing branches in %s", mirror)
+	}
+	for _, line := range strings.Split(heads, "\n") {
+		if branch, sha, ok := strings.Cut(line, " "); ok {
+			opts.pushes.record(project, branch, opts.pushRemote, sha, "pushed")
+		}
+	}
+
+	for _, name := range sortedKeys(h.skipped) {
+		opts.report.recordSkippedFile(project, historyBranch, name, h.skipped[name])
+	}
+	edits := []*fileEdit{}
+	for _, name := range sortedKeys(h.files) {
+		edits = append(edits, &fileEdit{name: name, matches: h.files[name]})
+	}
+	opts.report.recordBranch(project, historyBranch, journalCompleted, "", edits, "")
+	return nil
+}
+
+// run pipes fast-export through the rewriter into fast-import and returns each original commit and tag's new SHA.
+func (h *historyRewriter) run(mirror string) (map[string]string, error) {
+	marksPath := filepath.Join(mirror, "repoSed-marks")
+	export := exec.Command("git", "fast-export", "--branches", "--tags", "--no-data", "--show-original-ids", "--mark-tags",
+		"--signed-tags=strip", "--tag-of-filtered-object=rewrite", "--fake-missing-tagger", "--reencode=no", "--use-done-feature")
+	imp := exec.Command("git", "fast-import", "--quiet", "--force", "--export-marks="+marksPath)
+	cat := exec.Command("git", "cat-file", "--batch")
+	var exportErr, importErr, catErr strings.Builder
+	for _, c := range []struct {
+		cmd    *exec.Cmd
+		stderr *strings.Builder
+	}{{export, &exportErr}, {imp, &importErr}, {cat, &catErr}} {
+		c.cmd.Dir = mirror
+		c.cmd.Stderr = c.stderr
+	}
+
+	exportOut, err := export.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	importIn, err := imp.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	h.catIn, err = cat.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	catOut, err := cat.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	h.catOut = bufio.NewReader(catOut)
+	for _, cmd := range []*exec.Cmd{export, imp, cat} {
+		err = cmd.Start()
+		if err != nil {
+			return nil, fmt.Errorf("error starting %v: %v", cmd.Args, err)
+		}
+	}
+
+	out := bufio.NewWriter(importIn)
+	filterErr := h.filter(bufio.NewReader(exportOut), out)
+	if filterErr == nil {
+		filterErr = out.Flush()
+	}
+	if filterErr != nil {
+		// stop fast-export writing into a pipe nobody reads
+		export.Process.Kill()
+	}
+	importIn.Close()
+	h.catIn.Close()
+	if err := export.Wait(); err != nil {
+		h.logger.Printf("Error\t%s", exportErr.String())
+		return nil, fmt.Errorf("error exporting history in %s: %v", mirror, err)
+	}
+	if err := imp.Wait(); err != nil {
+		h.logger.Printf("Error\t%s", importErr.String())
+		return nil, fmt.Errorf("error importing rewritten history in %s: %v", mirror, err)
+	}
+	cat.Wait()
+	if filterErr != nil {
+		return nil, fmt.Errorf("error rewriting history in %s: %v", mirror, filterErr)
+	}
+
+	marks, err := os.ReadFile(marksPath)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(marksPath)
+	newSHAs := map[string]string{}
+	for _, line := range strings.Split(string(marks), "\n") {
+		if mark, sha, ok := strings.Cut(line, " "); ok {
+			newSHAs[mark] = sha
+		}
+	}
+	shaMap := map[string]string{}
+	for _, original := range h.originals {
+		shaMap[original] = newSHAs[h.marks[original]]
+	}
+	return shaMap, nil
+}
+
+// filter copies the fast-export stream to fast-import, rewriting file contents on the way.
+// data blocks are copied byte for byte, since commit messages can hold anything.
+func (h *historyRewriter) filter(in *bufio.Reader, out *bufio.Writer) error {
+	mark := ""
+	for {
+		line, err := in.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "data "):
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "data ")), 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad fast-export line %q", line)
+			}
+			out.WriteString(line)
+			_, err = io.CopyN(out, in, n)
+			if err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(line, "mark "):
+			mark = strings.TrimSpace(strings.TrimPrefix(line, "mark "))
+		case strings.HasPrefix(line, "original-oid "):
+			original := strings.TrimSpace(strings.TrimPrefix(line, "original-oid "))
+			h.originals = append(h.originals, original)
+			h.marks[original] = mark
+		case strings.HasPrefix(line, "M "):
+			err = h.fileModify(line, out)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		out.WriteString(line)
+	}
+}
+
+// fileModify rewrites an "M <mode> <sha> <path>" line, inlining the new content when the rules changed the blob.
+func (h *historyRewriter) fileModify(line string, out *bufio.Writer) error {
+	fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+	if len(fields) != 4 || fields[1] == "160000" || fields[1] == "120000" {
+		// submodule commits have no blob to rewrite, and a symlink's blob is its target path, not content
+		out.WriteString(line)
+		return nil
+	}
+	mode, sha, rawPath := fields[1], fields[2], fields[3]
+	name := rawPath
+	if strings.HasPrefix(rawPath, `"`) {
+		unquoted, err := strconv.Unquote(rawPath)
+		if err != nil {
+			return fmt.Errorf("bad path in fast-export line %q", line)
+		}
+		name = unquoted
+	}
+	fileRules := []rule{}
+	for _, r := range h.rules {
+		if r.targets(name) {
+			fileRules = append(fileRules, r)
+		}
+	}
+	if len(fileRules) == 0 {
+		out.WriteString(line)
+		return nil
+	}
+
+	data, err := h.rewriteBlob(sha, name, fileRules)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		out.WriteString(line)
+		return nil
+	}
+	fmt.Fprintf(out, "M %s inline %s\ndata %d\n", mode, rawPath, len(data))
+	out.Write(data)
+	out.WriteString("\n")
+	return nil
+}
+
+// rewriteBlob runs the rules over a blob, once per blob and set of rules, and returns the new content or nil.
+func (h *historyRewriter) rewriteBlob(sha string, name string, rules []rule) ([]byte, error) {
+	names := []string{}
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	key := sha + " " + strings.Join(names, ",")
+	if data, ok := h.blobs[key]; ok {
+		return data, nil
+	}
+	h.blobs[key] = nil
+
+	data, err := h.readBlob(sha)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		h.skipped[name] = fmt.Sprintf("larger than %d bytes", h.maxSize)
+		return nil, nil
+	}
+	// one log line per blob and rule would swamp the project log
+	edit, out, err := rewriteContent(log.New(io.Discard, "", 0), name, data, rules)
+	var skip *skipError
+	if errors.As(err, &skip) {
+		h.skipped[name] = skip.reason
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error rewriting %s (%s), %v", name, sha, err)
+	}
+	if edit != nil {
+		h.blobs[key] = out
+		if h.files[name] == nil {
+			h.files[name] = map[string]int{}
+		}
+		for rule, count := range edit.matches {
+			h.files[name][rule] += count
+		}
+	}
+	return h.blobs[key], nil
+}
+
+// readBlob reads a blob's content from the git cat-file --batch process.
+// It returns nil, without reading the content into memory, when the blob is larger than maxSize.
+func (h *historyRewriter) readBlob(sha string) ([]byte, error) {
+	fmt.Fprintf(h.catIn, "%s\n", sha)
+	header, err := h.catOut.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[1] != "blob" {
+		return nil, fmt.Errorf("unexpected object %q", strings.TrimSpace(header))
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	// the content is followed by a newline
+	if size > h.maxSize {
+		_, err = io.CopyN(io.Discard, h.catOut, size+1)
+		return nil, err
+	}
+	data := make([]byte, size+1)
+	_, err = io.ReadFull(h.catOut, data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:size], nil
+}
+
+// writeSHAMap writes one "old new" line per rewritten commit and tag.
+func writeSHAMap(filename string, shaMap map[string]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating SHA map %s, %v", filename, err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	for _, old := range sortedKeys(shaMap) {
+		fmt.Fprintf(w, "%s %s\n", old, shaMap[old])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// historyFixture lists a project whose first commit has nothing to rewrite, followed by a tagged commit adding
+// a config file and an oversized file, and a commit adding a symlink to a path the rule would match.
+func historyFixture(t *testing.T, f *fakeGitLab) (project Project, commits []string) {
+	t.Helper()
+	project = f.addProject("history", []string{"main"}, map[string]map[string]string{
+		"main": {"notes.txt": "plain\n"},
+	})
+	work := filepath.Join(t.TempDir(), "work")
+	gitRun(t, ".", "clone", "-q", project.URL, work)
+	files := map[string]string{
+		"config.yml": "url: old-host\n",
+		"big.txt":    "url: old-host\n" + strings.Repeat("x", 100) + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(work, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitRun(t, work, "add", "-A")
+	gitRun(t, work, "commit", "-q", "-m", "Add config")
+	gitRun(t, work, "tag", "-a", "-m", "First release", "v1")
+	if err := os.Symlink("old-host", filepath.Join(work, "host-link")); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, work, "add", "-A")
+	gitRun(t, work, "commit", "-q", "-m", "Add link")
+	gitRun(t, work, "push", "-q", "origin", "main", "v1")
+	commits = strings.Fields(gitRun(t, project.URL, "rev-list", "--reverse", "main"))
+	return project, commits
+}
+
+func TestDoTheWorkRewritesHistory(t *testing.T) {
+	f := newFakeGitLab(t)
+	project, commits := historyFixture(t, f)
+	opts := testOptions(t, f, "exec")
+	opts.rules = hostRules(t)
+	opts.maxFileSize = 64
+	opts.rewriteHistory = true
+	opts.pushRemote = "github"
+	opts.githubRemote = t.TempDir()
+	dest := filepath.Join(opts.githubRemote, "history.git")
+	gitRun(t, ".", "init", "-q", "--bare", dest)
+
+	_, erroreds := doTheWork(opts)
+	if len(erroreds) != 0 {
+		t.Fatalf("erroreds %v", erroreds)
+	}
+	if got := gitRun(t, dest, "show", "main:config.yml"); got != "url: new-host" {
+		t.Errorf("config.yml is %q", got)
+	}
+	if got := gitRun(t, dest, "show", "main~1:config.yml"); got != "url: new-host" {
+		t.Errorf("config.yml in the tagged commit is %q", got)
+	}
+	if got := gitRun(t, dest, "show", "main:big.txt"); !strings.HasPrefix(got, "url: old-host") {
+		t.Errorf("oversized big.txt was rewritten to %q", got)
+	}
+	if got := gitRun(t, dest, "cat-file", "-p", "main:host-link"); got != "old-host" {
+		t.Errorf("symlink target rewritten to %q", got)
+	}
+	if mode := strings.Fields(gitRun(t, dest, "ls-tree", "main", "host-link"))[0]; mode != "120000" {
+		t.Errorf("host-link has mode %s", mode)
+	}
+	branches := opts.report.Projects[0].Branches
+	if len(branches) != 1 || len(branches[0].Skipped) != 1 || branches[0].Skipped[0].Path != "big.txt" {
+		t.Errorf("report branches %+v", branches)
+	}
+
+	data, err := os.ReadFile(filepath.Join(opts.projectLogDir, projectKey(project)+".sha-map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaMap := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		old, sha, _ := strings.Cut(line, " ")
+		shaMap[old] = sha
+	}
+	// the first commit has nothing to rewrite, so it keeps its SHA
+	if shaMap[commits[0]] != commits[0] || gitRun(t, dest, "rev-parse", "main~2") != commits[0] {
+		t.Errorf("unchanged commit %s mapped to %s", commits[0], shaMap[commits[0]])
+	}
+	newMain := strings.Fields(gitRun(t, dest, "rev-list", "--reverse", "main"))
+	for i := 1; i < 3; i++ {
+		if shaMap[commits[i]] == commits[i] || shaMap[commits[i]] != newMain[i] {
+			t.Errorf("commit %s mapped to %s, want %s", commits[i], shaMap[commits[i]], newMain[i])
+		}
+	}
+	tag := gitRun(t, project.URL, "rev-parse", "v1")
+	if shaMap[tag] == "" || shaMap[tag] != gitRun(t, dest, "rev-parse", "v1") || gitRun(t, dest, "rev-parse", "v1^{}") != newMain[1] {
+		t.Errorf("tag v1 mapped to %q", shaMap[tag])
+	}
+
+	// rewritten history is only pushed to an empty destination, even from a fresh mirror
+	pushed := branchTips(t, dest)
+	opts.targetDir = filepath.Join(t.TempDir(), "repos")
+	opts.report = newReport(opts.runID)
+	_, erroreds = doTheWork(opts)
+	if len(erroreds) != 1 || !strings.Contains(opts.report.Projects[0].Error, "already has refs") {
+		t.Errorf("second rewrite erroreds %v, report %+v", erroreds, opts.report.Projects)
+	}
+	if tips := branchTips(t, dest); tips["main"] != pushed["main"] {
+		t.Errorf("second rewrite moved main")
+	}
+}
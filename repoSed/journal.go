@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	journalCompleted = "completed"
+	journalFailed    = "failed"
+	journalSkipped   = "skipped"
+	// journalBlocked is a branch a policy held back: protected, secret findings or missing images.
+	// Unlike skipped branches, -retry-failed tries them again once the cause is dealt with.
+	journalBlocked = "blocked"
+)
+
+// journalEntry is one line of the journal: the latest state of a project's branch.
+type journalEntry struct {
+	ProjectID int       `json:"project_id"`
+	Project   string    `json:"project"`
+	Branch    string    `json:"branch"`
+	State     string    `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// journal is an append-only JSON Lines file of branch states, so an interrupted run can resume.
+// Later lines for the same project and branch override earlier ones.
+type journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]journalEntry
+}
+
+// skipError marks a branch that was deliberately left alone rather than failed.
+// blocked marks one a policy held back, journaled as journalBlocked.
+type skipError struct {
+	reason  string
+	blocked bool
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+// state is the journal state for the skipped branch.
+func (e *skipError) state() string {
+	if e.blocked {
+		return journalBlocked
+	}
+	return journalSkipped
+}
+
+func journalKey(projectID int, branch string) string {
+	return fmt.Sprintf("%d/%s", projectID, branch)
+}
+
+// openJournal loads any existing entries from filename and opens it for appending.
+func openJournal(filename string) (*journal, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal %s: %v", filename, err)
+	}
+	j := &journal{file: file, entries: map[string]journalEntry{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a run killed mid-write can leave a partial last line
+			log.Printf("Info\tIgnoring unreadable journal line: %s", scanner.Text())
+			continue
+		}
+		j.entries[journalKey(entry.ProjectID, entry.Branch)] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading journal %s: %v", filename, err)
+	}
+	// end a partial last line so the next entry starts on its own line
+	info, err := file.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			file.Write([]byte("\n"))
+		}
+	}
+	return j, nil
+}
+
+func (j *journal) Close() error {
+	return j.file.Close()
+}
+
+// record appends the branch's new state. A nil journal records nothing.
+func (j *journal) record(project Project, branch string, state string, reason string) {
+	if j == nil {
+		return
+	}
+	entry := journalEntry{
+		ProjectID: project.ID,
+		Project:   project.Name,
+		Branch:    branch,
+		State:     state,
+		Reason:    reason,
+		Time:      time.Now(),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error\tjournal: %v", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[journalKey(project.ID, branch)] = entry
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error\tjournal: %v", err)
+	}
+}
+
+// shouldRun reports whether a branch still needs doing, and why not if it doesn't.
+// Branches with no entry are run; with retryFailed only failed and blocked branches are run.
+func (j *journal) shouldRun(project Project, branch string, retryFailed bool) (bool, string) {
+	if j == nil {
+		return true, ""
+	}
+	j.mu.Lock()
+	entry, ok := j.entries[journalKey(project.ID, branch)]
+	j.mu.Unlock()
+
+	switch {
+	case retryFailed && ok && (entry.State == journalFailed || entry.State == journalBlocked):
+		return true, ""
+	case retryFailed:
+		return false, "not a failed branch"
+	case ok:
+		return false, fmt.Sprintf("already %s at %s", entry.State, entry.Time.Format(time.RFC3339))
+	}
+	return true, ""
+}
+
+// pending reports whether any of the project's branches still need doing.
+func (j *journal) pending(project Project, retryFailed bool) bool {
+	for _, branch := range project.Branches {
+		if run, _ := j.shouldRun(project, branch.Name, retryFailed); run {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournalShouldRun(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := openJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project := Project{ID: 7, Name: "catalog"}
+	j.record(project, "done", journalCompleted, "")
+	j.record(project, "broken", journalFailed, "push rejected")
+	j.record(project, "skipped", journalSkipped, "nothing to do")
+	j.record(project, "blocked", journalBlocked, "push blocked by secret findings")
+	// a later entry overrides an earlier one
+	j.record(project, "fixed", journalFailed, "push rejected")
+	j.record(project, "fixed", journalCompleted, "")
+	j.Close()
+
+	// reopening reads the entries back, as a resumed run does
+	j, err = openJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	for _, tc := range []struct {
+		branch      string
+		retryFailed bool
+		want        bool
+	}{
+		{"new", false, true},
+		{"done", false, false},
+		{"broken", false, false},
+		{"skipped", false, false},
+		{"blocked", false, false},
+		{"fixed", false, false},
+		{"new", true, false},
+		{"done", true, false},
+		{"broken", true, true},
+		{"skipped", true, false},
+		{"blocked", true, true},
+		{"fixed", true, false},
+	} {
+		run, reason := j.shouldRun(project, tc.branch, tc.retryFailed)
+		if run != tc.want {
+			t.Errorf("shouldRun(%s, retryFailed=%v) = %v (%s), want %v", tc.branch, tc.retryFailed, run, reason, tc.want)
+		}
+		if !run && reason == "" {
+			t.Errorf("shouldRun(%s, retryFailed=%v) gave no reason", tc.branch, tc.retryFailed)
+		}
+	}
+	if run, _ := j.shouldRun(Project{ID: 8}, "done", false); !run {
+		t.Error("entries are keyed by project ID, another project's branch should run")
+	}
+	if !j.pending(Project{ID: 7, Branches: []Branch{{Name: "done"}, {Name: "new"}}}, false) {
+		t.Error("project with a new branch should be pending")
+	}
+	if j.pending(Project{ID: 7, Branches: []Branch{{Name: "done"}, {Name: "skipped"}}}, false) {
+		t.Error("project with every branch journaled should not be pending")
+	}
+}
+
+func TestJournalNil(t *testing.T) {
+	var j *journal
+	j.record(Project{ID: 1}, "main", journalCompleted, "")
+	if run, _ := j.shouldRun(Project{ID: 1}, "main", true); !run {
+		t.Error("a nil journal runs everything")
+	}
+}
+
+func TestJournalPartialLine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "journal.jsonl")
+	content := `{"project_id":7,"branch":"main","state":"completed"}` + "\n" + `{"project_id":7,"bra`
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j, err := openJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.record(Project{ID: 7}, "dev", journalFailed, "x")
+	j.Close()
+	data, _ := os.ReadFile(filename)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], `{"project_id":7`) {
+		t.Errorf("journal after a partial line:\n%s", data)
+	}
+	j, err = openJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if run, _ := j.shouldRun(Project{ID: 7}, "main", false); run {
+		t.Error("completed entry before the partial line was lost")
+	}
+}
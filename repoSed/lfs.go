@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// lfsPointerPrefix starts every Git LFS pointer file.
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+// usesLFS reports whether any branch's .gitattributes routes files through the LFS filter.
+func usesLFS(logger *log.Logger, folder string) (bool, error) {
+	refs, err := runCommand(logger, folder, "git", "for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return false, fmt.Errorf("error listing refs in folder %s", folder)
+	}
+	args := append([]string{"grep", "-l", "filter=lfs"}, strings.Fields(refs)...)
+	args = append(args, "--", ".gitattributes", "*/.gitattributes")
+	// git grep exits 1 when nothing matches
+	output, _ := runCommand(logger, folder, "git", args...)
+	return output != "", nil
+}
+
+// fetchLFS downloads the LFS objects for every ref, so each branch can be checked out and pushed with its files.
+func fetchLFS(logger *log.Logger, folder string) error {
+	_, err := runCommand(logger, folder, "git", "lfs", "version")
+	if err != nil {
+		return fmt.Errorf("folder %s uses Git LFS but git-lfs is not installed", folder)
+	}
+	_, err = runCommand(logger, folder, "git", "lfs", "fetch", "--all", "origin")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error fetching LFS objects in folder %s", folder)
+	}
+	return nil
+}
+
+// pullLFS replaces LFS pointers in the checked out branch with their files.
+func pullLFS(logger *log.Logger, folder string) error {
+	_, err := runCommand(logger, folder, "git", "lfs", "pull", "origin")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error pulling LFS objects in folder %s", folder)
+	}
+	return nil
+}
+
+// pushLFS uploads the LFS objects reachable from branch to remote.
+func pushLFS(logger *log.Logger, folder string, branch string, remote string) error {
+	_, err := runCommand(logger, folder, "git", "lfs", "push", remote, branch)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error pushing LFS objects for %s to %s in folder %s", branch, remote, folder)
+	}
+	return nil
+}
+
+// lfsObjectStats counts the LFS objects in the repository's local store and their total size.
+// The store sits in the git dir, which is .git in a clone and the repository itself in a bare mirror.
+func lfsObjectStats(logger *log.Logger, folder string) (count int, size int64, err error) {
+	gitDir, err := runCommand(logger, folder, "git", "rev-parse", "--git-dir")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return 0, 0, fmt.Errorf("error finding the git dir in folder %s", folder)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(folder, gitDir)
+	}
+	root := filepath.Join(gitDir, "lfs", "objects")
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			count++
+			size += info.Size()
+		}
+		return nil
+	})
+	return count, size, nil
+}
+
+// lfsPatterns reads every .gitattributes among files and returns the LFS-tracked patterns as repo-relative globs.
+func lfsPatterns(folder string, files []string) []string {
+	patterns := []string{}
+	for _, name := range files {
+		if path.Base(name) != ".gitattributes" {
+			continue
+		}
+		file, err := os.Open(filepath.Join(folder, name))
+		if err != nil {
+			continue
+		}
+		dir := path.Dir(name)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, attr := range fields[1:] {
+				if attr == "filter=lfs" {
+					patterns = append(patterns, attributeGlob(dir, fields[0]))
+					break
+				}
+			}
+		}
+		file.Close()
+	}
+	return patterns
+}
+
+// attributeGlob turns a .gitattributes pattern into a glob relative to the repo root.
+// Patterns without a slash match at any depth below the .gitattributes file.
+func attributeGlob(dir string, pattern string) string {
+	if !strings.Contains(strings.TrimPrefix(pattern, "/"), "/") && !strings.HasPrefix(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	if dir == "." {
+		return pattern
+	}
+	return dir + "/" + pattern
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLFSObjectStats(t *testing.T) {
+	f := newFakeGitLab(t)
+	catalog, _ := catalogFixture(f)
+	dir := t.TempDir()
+	clone := filepath.Join(dir, "catalog")
+	mirror := filepath.Join(dir, "catalog"+bareSuffix)
+	gitRun(t, ".", "clone", "-q", catalog.URL, clone)
+	gitRun(t, ".", "clone", "-q", "--mirror", catalog.URL, mirror)
+
+	logger := log.New(io.Discard, "", 0)
+	for _, tc := range []struct {
+		name, folder, gitDir string
+	}{
+		{"clone", clone, filepath.Join(clone, ".git")},
+		{"bare mirror", mirror, mirror},
+	} {
+		if count, size, err := lfsObjectStats(logger, tc.folder); err != nil || count != 0 || size != 0 {
+			t.Errorf("%s: empty store gave %d objects, %d bytes, %v", tc.name, count, size, err)
+		}
+		for oid, content := range map[string]string{"aa11": "first object", "bb22": "second"} {
+			object := filepath.Join(tc.gitDir, "lfs", "objects", oid[:2], oid[2:], oid)
+			if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(object, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count, size, err := lfsObjectStats(logger, tc.folder); err != nil || count != 2 || size != int64(len("first object")+len("second")) {
+			t.Errorf("%s: got %d objects, %d bytes, %v", tc.name, count, size, err)
+		}
+	}
+	if _, _, err := lfsObjectStats(logger, dir); err == nil {
+		t.Error("a folder outside any repository gave no error")
+	}
+}
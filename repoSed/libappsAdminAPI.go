@@ -1,20 +1,34 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
+// libappsAPIURL is the GitLab v4 API of libapps-admin, the default for -gitlab-api.
+const libappsAPIURL = "https://libapps-admin.uncw.edu/api/v4"
+
 type Image struct {
 	Name string `json:"name"`
 }
 
 type Branch struct {
-	Name    string `json:"name"`
-	Default bool   `json:"default"`
+	Name      string       `json:"name"`
+	Default   bool         `json:"default"`
+	Protected bool         `json:"protected"`
+	Merged    bool         `json:"merged"`
+	Commit    BranchCommit `json:"commit"`
+}
+
+type BranchCommit struct {
+	ID            string    `json:"id"`
+	CommittedDate time.Time `json:"committed_date"`
 }
 
 type Links struct {
@@ -31,10 +45,14 @@ type Project struct {
 	Links             Links  `json:"_links"`
 	Branches          []Branch
 	Images            []Image
+	LFS               bool
+	SecretFindings    int
+	// SecretFingerprints are the history's findings, which the rewrite scan doesn't count again
+	SecretFingerprints map[string]bool
 }
 
-func fetchLibappsPage(page int) ([]Project, error) {
-	url := fmt.Sprintf("https://libapps-admin.uncw.edu/api/v4/projects?page=%d&per_page=100", page)
+func fetchLibappsPage(apiURL string, page int) ([]Project, error) {
+	url := fmt.Sprintf("%s/projects?page=%d&per_page=100", apiURL, page)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -62,11 +80,11 @@ func fetchLibappsPage(page int) ([]Project, error) {
 	return projects, nil
 }
 
-func fetchLibappsProjects() ([]Project, error) {
+func fetchLibappsProjects(apiURL string) ([]Project, error) {
 	page := 1
 	projects := []Project{}
 	for {
-		pageProjects, err := fetchLibappsPage(page)
+		pageProjects, err := fetchLibappsPage(apiURL, page)
 		if err != nil {
 			return nil, err
 		}
@@ -79,7 +97,7 @@ func fetchLibappsProjects() ([]Project, error) {
 
 	for i := range projects {
 		enrichBranches(&projects[i])
-		enrichImages(&projects[i])
+		enrichImages(apiURL, &projects[i])
 	}
 
 	writeProjectsToFile(projects, "libapps-admin_projects.json")
@@ -127,8 +145,8 @@ func enrichBranches(project *Project) error {
 	return nil
 }
 
-func enrichImages(project *Project) error {
-	url := fmt.Sprintf("https://libapps-admin.uncw.edu/api/v4/projects/%d/registry/repositories", project.ID)
+func enrichImages(apiURL string, project *Project) error {
+	url := fmt.Sprintf("%s/projects/%d/registry/repositories", apiURL, project.ID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
@@ -152,5 +170,63 @@ func enrichImages(project *Project) error {
 	if err != nil {
 		return err
 	}
+	project.Images = images
 	return nil
 }
+
+type newMergeRequest struct {
+	SourceBranch       string `json:"source_branch"`
+	TargetBranch       string `json:"target_branch"`
+	Title              string `json:"title"`
+	Description        string `json:"description"`
+	RemoveSourceBranch bool   `json:"remove_source_branch"`
+}
+
+type mergeRequest struct {
+	WebURL string `json:"web_url"`
+}
+
+// openMergeRequest opens a merge request on the project and returns its URL.
+func openMergeRequest(apiURL string, project Project, source string, target string, title string, description string) (string, error) {
+	url := fmt.Sprintf("%s/projects/%d/merge_requests", apiURL, project.ID)
+	jsonData, err := json.Marshal(newMergeRequest{
+		SourceBranch:       source,
+		TargetBranch:       target,
+		Title:              title,
+		Description:        description,
+		RemoveSourceBranch: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	privateToken := os.Getenv("LIBAPPS_ADMIN_TOKEN")
+	req.Header.Set("PRIVATE-TOKEN", privateToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Sprintf("%s/-/merge_requests (already open for %s)", strings.TrimSuffix(project.URL, ".git"), source), nil
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("failed to open merge request, status code: %d %s", resp.StatusCode, body)
+	}
+
+	var mr mergeRequest
+	if err := json.Unmarshal(body, &mr); err != nil {
+		return "", err
+	}
+	return mr.WebURL, nil
+}
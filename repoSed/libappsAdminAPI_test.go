@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestFetchLibappsProjectsPages(t *testing.T) {
+	f := newFakeGitLab(t)
+	for i := 0; i < 120; i++ {
+		f.list(fmt.Sprintf("project-%03d", i), "", []Branch{{Name: "main", Default: true}})
+	}
+	f.list("with-images", "", []Branch{{Name: "main", Default: true}, {Name: "dev"}}, "with-images/web", "with-images/db")
+	testOptions(t, f, "exec")
+
+	projects, err := fetchLibappsProjects(f.apiURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 121 {
+		t.Fatalf("got %d projects, want 121 across two pages", len(projects))
+	}
+	last := projects[120]
+	if last.Name != "with-images" || len(last.Branches) != 2 || !last.Branches[0].Default || len(last.Images) != 2 {
+		t.Errorf("project not enriched: %+v", last)
+	}
+	if _, err := os.Stat("libapps-admin_projects.json"); err != nil {
+		t.Errorf("projects file not written: %v", err)
+	}
+}
+
+func TestFetchLibappsPageNeedsToken(t *testing.T) {
+	f := newFakeGitLab(t)
+	t.Setenv("LIBAPPS_ADMIN_TOKEN", "wrong")
+	_, err := fetchLibappsPage(f.apiURL(), 1)
+	if err == nil {
+		t.Error("expected an error for a rejected token")
+	}
+}
@@ -1,70 +1,259 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path"
-	"path/filepath"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
-type filechange struct {
-	filename    string
-	needle      string
-	replacement string
+// preparedBranch is a branch rewritten in its own worktree, waiting to be committed and pushed.
+type preparedBranch struct {
+	branch   Branch
+	worktree string
+	edits    []*fileEdit
 }
 
-func doBranch(folder string, branch Branch) error {
-	log.Printf("Starting branch\t%v", branch.Name)
-	err := checkoutBranch(folder, branch)
+// prepareBranch checks one branch out into its own worktree and rewrites it, leaving the commit to finishBranch.
+// Secret findings in the rewrite are added to the project's. On error the worktree is removed again.
+func prepareBranch(logger *log.Logger, folder string, project *Project, branch Branch, opts *options) (p *preparedBranch, err error) {
+	logger.Printf("Starting branch\t%v", branch.Name)
+	p = &preparedBranch{branch: branch, edits: []*fileEdit{}}
+	p.worktree, err = addWorktree(logger, folder, branch)
 	if err != nil {
-		log.Printf("Error\t%v", err)
-		return err
+		return p, err
+	}
+	defer func() {
+		if err != nil {
+			removeWorktree(logger, folder, p.worktree)
+		}
+	}()
+	worktree := p.worktree
+	err = gitFetchPull(logger, worktree, opts.git)
+	if err != nil {
+		return p, err
 	}
-	filechanges := []filechange{
-		{filename: "docker-compose.yml", needle: `image: libapps-admin.uncw.edu:8000/randall-dev/(.*?)`, replacement: "image: uncw-library/%s"},
-		{filename: "README.md", needle: `libapps-admin.uncw.edu:8000/randall-dev/(.*?)`, replacement: "uncw-library/%s"},
-		{filename: "README.md", needle: `libapps-admin.uncw.edu/randall-dev/(.*?)`, replacement: "github.com/uncw-library/%s"},
+	if project.LFS {
+		err = pullLFS(logger, worktree)
+		if err != nil {
+			return p, err
+		}
 	}
 
-	err = gitFetchPull(folder)
+	files, err := listFiles(worktree, opts.excludes)
 	if err != nil {
-		return err
+		return p, fmt.Errorf("error listing files in %s, %v", worktree, err)
+	}
+	lfsTracked := lfsPatterns(worktree, files)
+	for _, name := range files {
+		if matchesAny(lfsTracked, name) {
+			logger.Printf("Info\tSkipping file %s: LFS-tracked", name)
+			opts.report.recordSkippedFile(*project, branch.Name, name, "LFS-tracked")
+			continue
+		}
+		fileRules := []rule{}
+		for _, r := range opts.rules {
+			if r.targets(name) {
+				fileRules = append(fileRules, r)
+			}
+		}
+		if len(fileRules) == 0 {
+			continue
+		}
+		edit, err := editFile(logger, worktree, name, fileRules, opts.maxFileSize, opts.dryRun)
+		var skip *skipError
+		if errors.As(err, &skip) {
+			logger.Printf("Info\tSkipping file %s: %s", name, skip.reason)
+			opts.report.recordSkippedFile(*project, branch.Name, name, skip.reason)
+			continue
+		}
+		if err != nil {
+			return p, fmt.Errorf("error editing file %s, %v", path.Join(worktree, name), err)
+		}
+		if edit != nil {
+			p.edits = append(p.edits, edit)
+		}
+	}
+
+	edit, missing, err := rewriteSubmodules(logger, worktree, *project, opts.inventory, opts.dryRun)
+	if err != nil {
+		return p, fmt.Errorf("error rewriting submodules in %s, %v", worktree, err)
+	}
+	if edit != nil {
+		p.edits = append(p.edits, edit)
+	}
+	for _, m := range missing {
+		logger.Printf("Info\tSubmodule %s points at %s, which is not in the project inventory", m.Name, m.URL)
 	}
+	opts.report.recordMissingSubmodules(*project, branch.Name, missing)
 
-	for _, fc := range filechanges {
-		fullpath := path.Join(folder, fc.filename)
-		err := editFile(fullpath, fc.needle, fc.replacement)
+	if opts.translateCI {
+		edit, err := translateCIFile(logger, worktree, p.edits, opts.dryRun)
 		if err != nil {
-			return fmt.Errorf("error editing file %s, %v", fullpath, err)
+			return p, err
+		}
+		if edit != nil {
+			p.edits = append(p.edits, edit)
 		}
 	}
-	err = commitAndPushBranch(folder)
+
+	found, err := checkRewrites(logger, *project, branch, p.edits, opts)
 	if err != nil {
-		log.Printf("Error\t%v", err)
-		return err
+		return p, err
 	}
-	return nil
+	project.SecretFindings += found
+	return p, nil
+}
+
+// finishBranch commits a prepared branch and pushes it or opens its review, returning the commit it made, if any.
+// A dry run only records the diff.
+func finishBranch(logger *log.Logger, project Project, p *preparedBranch, opts *options) (commit string, err error) {
+	if opts.dryRun {
+		opts.diffs.record(project.Name, p.branch.Name, p.edits)
+		return "", nil
+	}
+	if opts.review {
+		commit, err = openReview(logger, p.worktree, project, p.branch, p.edits, opts)
+	} else {
+		commit, err = commitAndPushBranch(logger, p.worktree, project, p.branch, p.edits, opts)
+	}
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+	}
+	return commit, err
+}
+
+// checkRewrites looks up the image references the edits wrote and scans the lines they changed for secrets,
+// returning how many new secrets it found, or a *skipError when -image-check block finds missing images.
+func checkRewrites(logger *log.Logger, project Project, branch Branch, edits []*fileEdit, opts *options) (int, error) {
+	if opts.registry != nil {
+		missing := checkImages(logger, opts.registry, branch.Name, edits)
+		opts.report.recordMissingImages(project, missing)
+		if len(missing) > 0 && opts.imagePolicy == "block" && !opts.dryRun {
+			return 0, &skipError{reason: fmt.Sprintf("%d rewritten image references missing on the registry", len(missing)), blocked: true}
+		}
+	}
+
+	found := 0
+	if opts.scanSecrets {
+		for _, edit := range edits {
+			findings := scanAdded(edit.name, edit.before, edit.after, opts.secretAllow, project.SecretFingerprints)
+			for i := range findings {
+				findings[i].Commit = "rewrite of " + branch.Name
+			}
+			opts.secrets.record(project.Name, findings)
+			opts.report.recordSecrets(project, len(findings))
+			found += len(findings)
+		}
+	}
+	return found, nil
 }
 
-func doFolder(folder string, project Project) error {
-	log.Printf("Starting\tfolder: %v", folder)
+// doFolder clones the project and rewrites each selected branch in a worktree of its own beside the clone
+// (<folder>.worktrees/<branch>), so nothing left over from one branch reaches the next. Every branch is rewritten
+// and scanned before the first push, and the worktrees are removed once their branch is committed or pushed.
+func doFolder(logger *log.Logger, folder string, project Project, opts *options) error {
+	logger.Printf("Starting\tfolder: %v", folder)
 
-	err := gitClone(folder, project)
+	err := gitClone(logger, folder, project, opts.git)
+	if err != nil {
+		return err
+	}
+	if !opts.dryRun {
+		err = setupRemote(logger, folder, project, opts.pushRemote, opts)
+		if err != nil {
+			return err
+		}
+	}
+	// every branch gets its own worktree, so the clone itself holds none of them
+	err = detachHead(logger, folder)
 	if err != nil {
 		return err
 	}
+	project.LFS, err = usesLFS(logger, folder)
+	if err != nil {
+		return err
+	}
+	if project.LFS {
+		err = fetchLFS(logger, folder)
+		if err != nil {
+			return err
+		}
+		count, size, err := lfsObjectStats(logger, folder)
+		if err != nil {
+			return err
+		}
+		logger.Printf("Info\tLFS objects: %d, %d bytes", count, size)
+		opts.report.recordLFS(project, count, size)
+	}
+	if opts.scanSecrets {
+		findings, err := scanHistory(logger, folder, opts.secretAllow)
+		if err != nil {
+			return err
+		}
+		opts.secrets.record(project.Name, findings)
+		opts.report.recordSecrets(project, len(findings))
+		project.SecretFindings = len(findings)
+		project.SecretFingerprints = fingerprints(findings)
+		logger.Printf("Info\t%d secret findings in the history of %s", len(findings), project.Name)
+	}
 
-	// do each branch
-	log.Printf("%+v", project)
+	// do each branch: every one is rewritten and scanned before the first push,
+	// so a secret in any branch's rewrite blocks all of them
+	logger.Printf("%+v", project)
+	prepared := []*preparedBranch{}
+	defer func() {
+		for _, p := range prepared {
+			removeWorktree(logger, folder, p.worktree)
+		}
+	}()
 	for _, branch := range project.Branches {
-		err = doBranch(folder, branch)
+		if ok, reason := opts.branches.allows(branch, time.Now()); !ok {
+			logger.Printf("Info\tSkipping branch %s: %s", branch.Name, reason)
+			opts.report.recordBranch(project, branch.Name, journalSkipped, reason, nil, "")
+			continue
+		}
+		if run, reason := opts.journal.shouldRun(project, branch.Name, opts.retryFailed); !run {
+			logger.Printf("Info\tSkipping branch %s: %s", branch.Name, reason)
+			opts.report.recordBranch(project, branch.Name, journalSkipped, reason, nil, "")
+			continue
+		}
+		p, err := prepareBranch(logger, folder, &project, branch, opts)
+		var skip *skipError
+		if errors.As(err, &skip) {
+			logger.Printf("Info\tSkipped branch %s: %s", branch.Name, skip.reason)
+			opts.journal.record(project, branch.Name, skip.state(), skip.reason)
+			opts.report.recordBranch(project, branch.Name, skip.state(), skip.reason, p.edits, "")
+			continue
+		}
 		if err != nil {
+			opts.journal.record(project, branch.Name, journalFailed, err.Error())
+			opts.report.recordBranch(project, branch.Name, journalFailed, err.Error(), p.edits, "")
 			return err
 		}
+		prepared = append(prepared, p)
+	}
+	for _, p := range prepared {
+		commit, err := finishBranch(logger, project, p, opts)
+		removeWorktree(logger, folder, p.worktree)
+		var skip *skipError
+		if errors.As(err, &skip) {
+			logger.Printf("Info\tSkipped branch %s: %s", p.branch.Name, skip.reason)
+			opts.journal.record(project, p.branch.Name, skip.state(), skip.reason)
+			opts.report.recordBranch(project, p.branch.Name, skip.state(), skip.reason, p.edits, commit)
+			continue
+		}
+		if err != nil {
+			opts.journal.record(project, p.branch.Name, journalFailed, err.Error())
+			opts.report.recordBranch(project, p.branch.Name, journalFailed, err.Error(), p.edits, commit)
+			return err
+		}
+		opts.journal.record(project, p.branch.Name, journalCompleted, "")
+		opts.report.recordBranch(project, p.branch.Name, journalCompleted, "", p.edits, commit)
 	}
 
 	// // return folder to the default branch
@@ -74,8 +263,8 @@ func doFolder(folder string, project Project) error {
 			defaultBranch = branch
 		}
 	}
-	log.Printf("Returning to starting branch: %s\n", defaultBranch.Name)
-	err = checkoutBranch(folder, defaultBranch)
+	logger.Printf("Returning to starting branch: %s\n", defaultBranch.Name)
+	err = checkoutBranch(logger, folder, defaultBranch, opts.git)
 	if err != nil {
 		return err
 	}
@@ -83,14 +272,14 @@ func doFolder(folder string, project Project) error {
 	return nil
 }
 
-func setup() *os.File {
+func setup(runID string) *os.File {
 	// set up logging
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	err := os.Mkdir("logs", 0755)
 	if err != nil && !os.IsExist(err) {
 		log.Fatalf("Failed to create directory: %v", err)
 	}
-	logpath := path.Join("logs", fmt.Sprintf("logs-%v.log", time.Now().Format("20060102_150405")))
+	logpath := path.Join("logs", fmt.Sprintf("logs-%v.log", runID))
 	logFile, err := os.OpenFile(logpath, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatalf("Failed to open log file: %v", err)
@@ -105,43 +294,92 @@ func setup() *os.File {
 	return logFile
 }
 
-func doTheWork(targetDir string) (successes []string, erroreds []string) {
+func doTheWork(opts *options) (successes []string, erroreds []string) {
 	// do the work
 	successes, erroreds = []string{}, []string{}
-	libappsProjects, err := fetchLibappsProjects()
+	libappsProjects, err := fetchLibappsProjects(opts.apiURL)
 	if err != nil {
 		log.Fatalf("Error fetching libapps projects: %v", err)
 	}
 
-	for _, project := range libappsProjects {
-		dest := filepath.Join(targetDir, project.Name)
+	// submodules may point at projects the filters leave out
+	opts.inventory = projectInventory(libappsProjects)
+	libappsProjects = filterProjects(libappsProjects, opts.filter)
 
-		// DEBUG:  ONLY DO ONE REPO
-		// if dest != "/Users/armstrongg/Desktop/all_gitlab_cloned/d8-staff" {
-		// 	continue
-		// }
+	err = os.MkdirAll(opts.projectLogDir, 0755)
+	if err != nil {
+		log.Fatalf("Failed to create directory: %v", err)
+	}
 
-		err = doFolder(dest, project)
-		if err != nil {
-			erroreds = append(erroreds, dest)
-			log.Printf("Error\t%v", err)
+	for _, result := range runProjects(libappsProjects, opts) {
+		if result.err != nil {
+			erroreds = append(erroreds, result.folder)
 			continue
 		}
-		successes = append(successes, dest)
+		successes = append(successes, result.folder)
 	}
 	return successes, erroreds
 }
 
 func main() {
-	logFile := setup()
+	opts, err := parseOptions()
+	if err != nil {
+		log.Fatalf("Error\t%v", err)
+	}
+
+	opts.runID = time.Now().Format("20060102_150405")
+	opts.projectLogDir = path.Join("logs", opts.runID)
+	logFile := setup(opts.runID)
 	defer logFile.Close()
+	log.Printf("Loaded %d rules from %s", len(opts.rules), opts.rulesPath)
 
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: repo_sed <targetDir>")
+	// the journal tracks branch rewrites; rollbacks, history rewrites, verifies and dry runs always look at every branch
+	if !opts.dryRun && !opts.rollback && !opts.rewriteHistory && opts.verifyRemote == "" {
+		j, err := openJournal(opts.journalPath)
+		if err != nil {
+			log.Fatalf("Error\t%v", err)
+		}
+		defer j.Close()
+		opts.journal = j
 	}
-	targetDir := os.Args[1]
 
-	successes, erroreds := doTheWork(targetDir)
+	if opts.dryRun && !opts.rollback {
+		diffFile := openDiffFile(opts.runID)
+		defer diffFile.Close()
+		opts.diffs = &diffLog{out: diffFile, projects: map[string]bool{}}
+	}
+
+	opts.report = newReport(opts.runID)
+	opts.report.DryRun = opts.dryRun
+	successes, erroreds := doTheWork(opts)
 	log.Printf("Successes\t%v", successes)
 	log.Printf("Erroreds\t%v", erroreds)
+	if opts.pushRemote != "" {
+		for _, p := range opts.pushes.results {
+			log.Printf("Push\t%s\t%s\t%s\t%s\t%s", p.project, p.branch, p.remote, p.status, p.sha)
+		}
+	}
+	if opts.rollback {
+		for _, r := range opts.rollbacks.results {
+			log.Printf("Rollback\t%s\t%s\t%s\t%v", r.project, r.branch, r.status, r.reverted)
+		}
+	}
+	if !opts.rollback && opts.verifyRemote == "" {
+		writeReport(opts.report, opts.pushes)
+	}
+	if opts.scanSecrets {
+		opts.secrets.write(opts.runID)
+	}
+	if opts.dryRun && !opts.rollback {
+		summary := opts.diffs.summary()
+		log.Print(summary)
+		fmt.Printf("%s\nDiffs written to %s\n", summary, opts.diffs.out.Name())
+	}
+	if opts.verifyRemote != "" {
+		left := opts.verifies.write(opts.runID)
+		if left > 0 || len(erroreds) > 0 {
+			logFile.Close()
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	composeBefore = "services:\n  web:\n    image: libapps-admin.uncw.edu:8000/randall-dev/catalog/web:latest\n"
+	composeAfter  = "services:\n  web:\n    image: uncw-library/catalog-web:latest\n"
+	readmeBefore  = "Source: https://libapps-admin.uncw.edu/randall-dev/catalog\n"
+	readmeAfter   = "Source: https://github.com/uncw-library/catalog\n"
+)
+
+// inTempDir moves the test into a fresh directory, where logs and reports get written, until it ends.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(cwd); err != nil {
+			t.Errorf("restoring the working directory: %v", err)
+		}
+	})
+	return dir
+}
+
+// testOptions sets up a run the way main does, working in a fresh directory so logs and reports stay out of the tree.
+func testOptions(t *testing.T, f *fakeGitLab, gitImpl string) *options {
+	t.Helper()
+	rules, excludes, err := loadRules("rules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := inTempDir(t)
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	opts := &options{
+		targetDir:   filepath.Join(work, "repos"),
+		runID:       "20260101_120000",
+		rules:       rules,
+		excludes:    excludes,
+		maxFileSize: 1 << 20,
+		concurrency: 1,
+		apiURL:      f.apiURL(),
+		pushes:      &pushLog{},
+		rollbacks:   &rollbackLog{},
+		secrets:     &secretLog{},
+		verifies:    &verifyLog{},
+	}
+	opts.projectLogDir = filepath.Join("logs", opts.runID)
+	opts.report = newReport(opts.runID)
+	opts.filter.archived = "include"
+	opts.branches.mode = "all"
+	opts.git, err = newGitClient(gitImpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.commitTemplate, err = parseCommitTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.verifyPatterns, err = loadVerifyPatterns(filepath.Join(cwd, "rules.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// catalogFixture lists a project whose two branches need rewriting and one that needs nothing.
+func catalogFixture(f *fakeGitLab) (catalog Project, plain Project) {
+	catalog = f.addProject("catalog", []string{"main", "feature"}, map[string]map[string]string{
+		"main":    {"docker-compose.yml": composeBefore, "README.md": readmeBefore},
+		"feature": {"notes.txt": "feature work\n"},
+	}, "catalog/web")
+	plain = f.addProject("plain", []string{"main"}, map[string]map[string]string{
+		"main": {"README.md": "Nothing to migrate here.\n"},
+	})
+	return catalog, plain
+}
+
+// pushModes are the ways a run can rewrite and push: worktree clones with either git client, or a bare mirror,
+// which only runs the git binary.
+var pushModes = []struct {
+	name    string
+	bare    bool
+	gitImpl string
+}{
+	{"worktree/go", false, "go"},
+	{"worktree/exec", false, "exec"},
+	{"bare", true, "go"},
+}
+
+func TestDoTheWorkPushesRewrittenBranches(t *testing.T) {
+	for _, mode := range pushModes {
+		t.Run(mode.name, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			catalog, plain := catalogFixture(f)
+			before := branchTips(t, catalog.URL)
+			plainBefore := branchTips(t, plain.URL)
+			opts := testOptions(t, f, mode.gitImpl)
+			opts.pushRemote = "origin"
+			opts.bare = mode.bare
+
+			successes, erroreds := doTheWork(opts)
+			if len(erroreds) != 0 || len(successes) != 2 {
+				t.Fatalf("successes %v, erroreds %v", successes, erroreds)
+			}
+			if _, err := os.Stat(filepath.Join(opts.targetDir, projectKey(catalog))); mode.bare && !os.IsNotExist(err) {
+				t.Errorf("bare mode made a working clone: %v", err)
+			}
+
+			after := branchTips(t, catalog.URL)
+			for _, branch := range []string{"main", "feature"} {
+				if after[branch] == before[branch] {
+					t.Errorf("%s was not pushed", branch)
+					continue
+				}
+				parent := gitRun(t, catalog.URL, "rev-parse", branch+"^")
+				if parent != before[branch] {
+					t.Errorf("%s: new commit's parent is %s, want %s", branch, parent, before[branch])
+				}
+				message := gitRun(t, catalog.URL, "log", "-1", "--format=%B", branch)
+				if !strings.HasPrefix(message, "Updating git & image references") {
+					t.Errorf("%s: unexpected commit message %q", branch, message)
+				}
+				if !strings.Contains(message, "Migration-Run: "+opts.runID) || !strings.Contains(message, "Migration-Rules: compose-image, readme-repo") {
+					t.Errorf("%s: missing trailers in %q", branch, message)
+				}
+				if got := gitRun(t, catalog.URL, "show", branch+":docker-compose.yml") + "\n"; got != composeAfter {
+					t.Errorf("%s: docker-compose.yml is %q", branch, got)
+				}
+				if got := gitRun(t, catalog.URL, "show", branch+":README.md") + "\n"; got != readmeAfter {
+					t.Errorf("%s: README.md is %q", branch, got)
+				}
+			}
+			if got := gitRun(t, catalog.URL, "show", "feature:notes.txt"); got != "feature work" {
+				t.Errorf("feature: notes.txt is %q", got)
+			}
+			if plainAfter := branchTips(t, plain.URL); plainAfter["main"] != plainBefore["main"] {
+				t.Errorf("plain got a commit it didn't need")
+			}
+			if len(opts.report.Projects) != 2 || opts.report.Projects[0].Status != "success" || len(opts.report.Projects[0].Branches) != 2 {
+				t.Fatalf("report projects %+v", opts.report.Projects)
+			}
+			if opts.report.Projects[0].Branches[0].Commit == "" {
+				t.Errorf("report branch %+v has no commit", opts.report.Projects[0].Branches[0])
+			}
+
+			// a second run finds nothing left to rewrite and pushes nothing
+			opts.report = newReport(opts.runID)
+			opts.pushes = &pushLog{}
+			_, erroreds = doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("second run erroreds %v", erroreds)
+			}
+			for branch, sha := range branchTips(t, catalog.URL) {
+				if after[branch] != sha {
+					t.Errorf("second run moved %s", branch)
+				}
+			}
+		})
+	}
+}
+
+func TestDoTheWorkDryRunChangesNothing(t *testing.T) {
+	f := newFakeGitLab(t)
+	catalog, _ := catalogFixture(f)
+	before := branchTips(t, catalog.URL)
+	opts := testOptions(t, f, "go")
+	opts.dryRun = true
+	diffFile, err := os.Create("dry-run.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diffFile.Close()
+	opts.diffs = &diffLog{out: diffFile, projects: map[string]bool{}}
+
+	_, erroreds := doTheWork(opts)
+	if len(erroreds) != 0 {
+		t.Fatalf("erroreds %v", erroreds)
+	}
+	after := branchTips(t, catalog.URL)
+	for branch, sha := range before {
+		if after[branch] != sha {
+			t.Errorf("dry run moved %s", branch)
+		}
+	}
+	diff, err := os.ReadFile("dry-run.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(diff), "+    image: uncw-library/catalog-web:latest") {
+		t.Errorf("diff is missing the compose rewrite:\n%s", diff)
+	}
+}
+
+func TestDoTheWorkReviewOpensMergeRequests(t *testing.T) {
+	f := newFakeGitLab(t)
+	catalog, _ := catalogFixture(f)
+	before := branchTips(t, catalog.URL)
+	opts := testOptions(t, f, "go")
+	opts.pushRemote = "origin"
+	opts.review = true
+
+	_, erroreds := doTheWork(opts)
+	if len(erroreds) != 0 {
+		t.Fatalf("erroreds %v", erroreds)
+	}
+	after := branchTips(t, catalog.URL)
+	for _, branch := range []string{"main", "feature"} {
+		if after[branch] != before[branch] {
+			t.Errorf("review mode moved %s", branch)
+		}
+		review := reviewBranchName(Branch{Name: branch, Default: branch == "main"})
+		if gitRun(t, catalog.URL, "rev-parse", review+"^") != before[branch] {
+			t.Errorf("%s does not build on %s", review, branch)
+		}
+	}
+	if len(f.mergeRequests) != 2 {
+		t.Fatalf("got %d merge requests, want 2", len(f.mergeRequests))
+	}
+	// the review branch's push is reported on the branch it was opened for
+	opts.report.addPushes(opts.pushes)
+	for _, br := range opts.report.Projects[0].Branches {
+		if br.PushStatus != "pushed" || br.Review != reviewBranchName(Branch{Name: br.Name, Default: br.Name == "main"}) {
+			t.Errorf("report branch %+v", br)
+		}
+	}
+	for _, mr := range f.mergeRequests {
+		want := reviewBranchName(Branch{Name: mr.TargetBranch, Default: mr.TargetBranch == "main"})
+		if mr.SourceBranch != want || !strings.Contains(mr.Description, "compose-image") {
+			t.Errorf("unexpected merge request %+v", mr)
+		}
+	}
+}
+
+func TestDoTheWorkPushesToGithubRemote(t *testing.T) {
+	for _, mode := range pushModes {
+		t.Run(mode.name, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			catalog, _ := catalogFixture(f)
+			before := branchTips(t, catalog.URL)
+			github := t.TempDir()
+			gitRun(t, github, "init", "-q", "--bare", "catalog.git")
+			gitRun(t, github, "init", "-q", "--bare", "plain.git")
+			opts := testOptions(t, f, mode.gitImpl)
+			opts.pushRemote = "github"
+			opts.githubRemote = github
+			opts.scanSecrets = true
+			opts.bare = mode.bare
+
+			_, erroreds := doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("erroreds %v", erroreds)
+			}
+			if after := branchTips(t, catalog.URL); after["main"] != before["main"] {
+				t.Errorf("pushing to github moved GitLab's main")
+			}
+			pushed := branchTips(t, filepath.Join(github, "catalog.git"))
+			if pushed["main"] == "" || pushed["feature"] == "" {
+				t.Fatalf("github remote has %v", pushed)
+			}
+			if got := gitRun(t, filepath.Join(github, "catalog.git"), "show", "main:docker-compose.yml") + "\n"; got != composeAfter {
+				t.Errorf("docker-compose.yml is %q", got)
+			}
+			if plainPushed := branchTips(t, filepath.Join(github, "plain.git")); plainPushed["main"] == "" {
+				t.Errorf("unchanged plain was not mirrored to github")
+			}
+		})
+	}
+}
+
+// lfsFixture installs a stand-in git-lfs whose smudge filter changes the pointer's first line and whose clean filter
+// changes it back, so a commit that skips the clean filter stores the smudged content instead of the pointer.
+func lfsFixture(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	err := os.WriteFile(filepath.Join(bin, "git-lfs"), []byte("#!/bin/sh\nexit 0\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	config, err := os.OpenFile(filepath.Join(os.Getenv("HOME"), ".gitconfig"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer config.Close()
+	_, err = config.WriteString("[filter \"lfs\"]\n\tclean = sed 's/^smudged /version /'\n\tsmudge = sed 's/^version /smudged /'\n\trequired = true\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDoTheWorkKeepsLFSPointers(t *testing.T) {
+	pointer := lfsPointerPrefix + "\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+	for _, gitImpl := range []string{"go", "exec"} {
+		t.Run(gitImpl, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			lfsFixture(t)
+			catalog := f.addProject("catalog", []string{"main"}, map[string]map[string]string{
+				"main": {".gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n", "logo.png": pointer, "README.md": readmeBefore},
+			})
+			before := branchTips(t, catalog.URL)
+			opts := testOptions(t, f, gitImpl)
+			opts.pushRemote = "origin"
+
+			_, erroreds := doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("erroreds %v", erroreds)
+			}
+			if after := branchTips(t, catalog.URL); after["main"] == before["main"] {
+				t.Fatal("main was not pushed")
+			}
+			if got := gitRun(t, catalog.URL, "show", "main:README.md") + "\n"; got != readmeAfter {
+				t.Errorf("README.md is %q", got)
+			}
+			if got := gitRun(t, catalog.URL, "show", "main:logo.png") + "\n"; got != pointer {
+				t.Errorf("logo.png was committed as %q, not its LFS pointer", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// options holds the command line settings for a run.
+type options struct {
+	targetDir string
+	rulesPath string
+	runID     string
+	rules     []rule
+	excludes  []string
+	dryRun    bool
+	diffs     *diffLog
+
+	maxFileSize int64
+
+	pushRemote     string
+	allowProtected bool
+	pushes         *pushLog
+	review         bool
+
+	concurrency   int
+	projectLogDir string
+
+	journalPath string
+	retryFailed bool
+	journal     *journal
+
+	filter   projectFilter
+	branches branchPolicy
+
+	rollback    bool
+	rollbackRun string
+	rollbacks   *rollbackLog
+
+	report *report
+
+	translateCI bool
+
+	rewriteHistory bool
+
+	scanSecrets bool
+	secretAllow *secretAllowlist
+	secrets     *secretLog
+
+	commitName     string
+	commitEmail    string
+	commitTemplate *template.Template
+	sign           string
+	signingKey     string
+
+	inventory map[string]Project
+
+	git gitClient
+
+	apiURL       string
+	githubRemote string
+
+	verifyRemote   string
+	verifyPatterns []verifyPattern
+	verifies       *verifyLog
+
+	imagePolicy string
+	registry    *registryChecker
+
+	bare bool
+}
+
+func parseOptions() (*options, error) {
+	opts := &options{}
+	var include, exclude, pathPrefixes, visibility, projectList, branchMatch, author, commitTemplate, allowlist, gitImpl, registryURL string
+	flag.StringVar(&opts.rulesPath, "rules", "rules.yaml", "YAML or JSON file of rewrite rules")
+	flag.Int64Var(&opts.maxFileSize, "max-file-size", 1<<20, "skip files larger than this many bytes instead of rewriting them")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "check out every branch and write unified diffs instead of editing and committing")
+	flag.StringVar(&opts.pushRemote, "push", "", `push rewritten branches to "origin" (GitLab) or "github"; empty means commit locally only`)
+	flag.BoolVar(&opts.allowProtected, "allow-protected", false, "also push branches GitLab marks as protected")
+	flag.BoolVar(&opts.review, "review", false, "commit onto "+reviewBranchPrefix+" branches and open a merge/pull request instead of committing to each branch; needs -push")
+	flag.IntVar(&opts.concurrency, "concurrency", 1, "number of projects to process at once")
+	flag.StringVar(&opts.journalPath, "journal", "journal.jsonl", "file recording each branch's state so an interrupted run resumes where it stopped")
+	flag.BoolVar(&opts.retryFailed, "retry-failed", false, "only re-attempt branches the journal records as failed")
+	flag.StringVar(&include, "include", "", "only process projects whose name matches this regexp")
+	flag.StringVar(&exclude, "exclude", "", "skip projects whose name matches this regexp")
+	flag.StringVar(&pathPrefixes, "path-prefix", "", "only process projects whose path_with_namespace starts with one of these comma separated prefixes")
+	flag.StringVar(&opts.filter.archived, "archived", "include", `archived projects: "include", "exclude" or "only"`)
+	flag.StringVar(&visibility, "visibility", "", "only process projects with one of these comma separated visibilities (private, internal, public)")
+	flag.StringVar(&opts.branches.mode, "branches", "all", `which branches to rewrite: "all" or only the "default" branch`)
+	flag.StringVar(&branchMatch, "branch-match", "", "only rewrite branches whose name matches this regexp")
+	flag.IntVar(&opts.branches.newerThan, "newer-than", 0, "only rewrite branches with a commit in the last N days; 0 means any age")
+	flag.BoolVar(&opts.branches.unmerged, "unmerged", false, "skip branches already merged into the default branch")
+	flag.StringVar(&projectList, "projects", "", "file listing the project names or paths to process, one per line")
+	flag.BoolVar(&opts.rollback, "rollback", false, "revert the commits repoSed made on every branch instead of rewriting; pushes with -push, lists only with -dry-run")
+	flag.StringVar(&opts.rollbackRun, "rollback-run", "", "with -rollback, only revert commits from this run ID (the Migration-Run trailer)")
+	flag.BoolVar(&opts.translateCI, "translate-ci", false, "also translate .gitlab-ci.yml into "+ciWorkflowPath+" on each branch")
+	flag.BoolVar(&opts.rewriteHistory, "rewrite-history", false, "apply the rules to every commit and tag in a fresh mirror clone and push it to the empty -push destination, writing an old to new SHA map")
+	flag.BoolVar(&opts.scanSecrets, "scan-secrets", false, "scan each project's history and rewrites for tokens, keys and high-entropy strings, and block its pushes on any finding; always on with -push github")
+	flag.StringVar(&allowlist, "secrets-allowlist", "", `file of known false positives for -scan-secrets: "path:<glob>", "match:<regexp>" or a finding's fingerprint per line`)
+	flag.StringVar(&author, "author", "", `author and committer for repoSed's commits, as "Name <email>"; empty uses your git config`)
+	flag.StringVar(&commitTemplate, "commit-template", "", "text/template file for commit messages, given .RunID, .Project, .Branch, .Rules and .Files; trailers are always added")
+	flag.StringVar(&opts.sign, "sign", "", `sign commits with "gpg" or "ssh"`)
+	flag.StringVar(&opts.signingKey, "signing-key", "", "key for -sign: a GPG key ID, or an SSH public key file (required for ssh)")
+	flag.StringVar(&opts.verifyRemote, "verify", "", `instead of rewriting, grep every branch on "origin" or "github" for the rules file's verify patterns and exit 1 if any are left`)
+	flag.StringVar(&opts.imagePolicy, "image-check", "off", `look up the image references rules marked image write on the registry: "off", "flag" missing ones in the report, or "block" the branch's commit`)
+	flag.StringVar(&registryURL, "registry", dockerHubRegistry, "registry API for image references without a registry host")
+	flag.BoolVar(&opts.bare, "bare", false, "rewrite branches in a bare mirror clone, building each commit from the object database without a working tree, and push every updated branch in one push; needs -push or -dry-run")
+	flag.StringVar(&opts.apiURL, "gitlab-api", libappsAPIURL, "GitLab v4 API to list projects, branches and images from and open merge requests on")
+	flag.StringVar(&opts.githubRemote, "github-remote", "", `base URL or folder for the "github" push remote, pushing to <github-remote>/<project>.git instead of the project's GitHub repo`)
+	flag.StringVar(&gitImpl, "git", "go", `run clone, checkout, commit and push "go" in process (falling back to the git binary where needed) or "exec" with the git binary`)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: repo_sed [flags] <targetDir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.targetDir = flag.Arg(0)
+	if opts.pushRemote != "" && opts.pushRemote != "origin" && opts.pushRemote != "github" {
+		return nil, fmt.Errorf(`-push must be "origin" or "github", not %q`, opts.pushRemote)
+	}
+	if opts.review && opts.pushRemote == "" {
+		return nil, fmt.Errorf("-review needs -push origin or -push github")
+	}
+	if opts.concurrency < 1 {
+		return nil, fmt.Errorf("-concurrency must be at least 1, not %d", opts.concurrency)
+	}
+	err := opts.filter.parse(include, exclude, pathPrefixes, visibility, projectList)
+	if err != nil {
+		return nil, err
+	}
+	err = opts.branches.parse(branchMatch)
+	if err != nil {
+		return nil, err
+	}
+	if opts.review && opts.rollback {
+		return nil, fmt.Errorf("-review and -rollback can't be used together")
+	}
+	if opts.rewriteHistory && (opts.dryRun || opts.review || opts.rollback) {
+		return nil, fmt.Errorf("-rewrite-history can't be used with -dry-run, -review or -rollback")
+	}
+	if opts.rewriteHistory && opts.pushRemote == "" {
+		return nil, fmt.Errorf("-rewrite-history needs -push to name the fresh mirror it pushes to")
+	}
+	if opts.verifyRemote != "" && opts.verifyRemote != "origin" && opts.verifyRemote != "github" {
+		return nil, fmt.Errorf(`-verify must be "origin" or "github", not %q`, opts.verifyRemote)
+	}
+	if opts.verifyRemote != "" && (opts.dryRun || opts.review || opts.rollback || opts.rewriteHistory || opts.pushRemote != "") {
+		return nil, fmt.Errorf("-verify can't be used with -dry-run, -review, -rollback, -rewrite-history or -push")
+	}
+	if opts.bare && (opts.review || opts.rollback || opts.rewriteHistory || opts.verifyRemote != "") {
+		return nil, fmt.Errorf("-bare can't be used with -review, -rollback, -rewrite-history or -verify")
+	}
+	if opts.bare && opts.pushRemote == "" && !opts.dryRun {
+		// the next fetch resets the mirror's branches, so commits that aren't pushed would be lost
+		return nil, fmt.Errorf("-bare needs -push or -dry-run")
+	}
+	if opts.imagePolicy != "off" && opts.imagePolicy != "flag" && opts.imagePolicy != "block" {
+		return nil, fmt.Errorf(`-image-check must be "off", "flag" or "block", not %q`, opts.imagePolicy)
+	}
+	if opts.imagePolicy != "off" {
+		opts.registry = newRegistryChecker(registryURL)
+	}
+	if opts.sign != "" && opts.sign != "gpg" && opts.sign != "ssh" {
+		return nil, fmt.Errorf(`-sign must be "gpg" or "ssh", not %q`, opts.sign)
+	}
+	if opts.sign == "ssh" && opts.signingKey == "" {
+		return nil, fmt.Errorf("-sign ssh needs -signing-key")
+	}
+	opts.apiURL = strings.TrimSuffix(opts.apiURL, "/")
+	opts.git, err = newGitClient(gitImpl)
+	if err != nil {
+		return nil, err
+	}
+	if author != "" {
+		opts.commitName, opts.commitEmail, err = parseIdentity(author)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.commitTemplate, err = parseCommitTemplate(commitTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if opts.pushRemote == "github" {
+		opts.scanSecrets = true
+	}
+	opts.secretAllow, err = loadAllowlist(allowlist)
+	if err != nil {
+		return nil, err
+	}
+	opts.secrets = &secretLog{}
+	opts.pushes = &pushLog{}
+	opts.rollbacks = &rollbackLog{}
+
+	rules, excludes, err := loadRules(opts.rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	opts.rules, opts.excludes = rules, excludes
+	opts.verifyPatterns, err = loadVerifyPatterns(opts.rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	opts.verifies = &verifyLog{}
+	return opts, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "sync"
+
+// githubOrg is the GitHub organization projects are migrated to.
+const githubOrg = "uncw-library"
+
+type pushResult struct {
+	projectID int
+	project   string
+	branch    string
+	// review is the branch actually pushed when -review pushed branch's edits to a review branch
+	review string
+	remote string
+	sha    string
+	status string
+}
+
+// pushLog keeps the outcome of every push attempted during a run.
+type pushLog struct {
+	mu      sync.Mutex
+	results []pushResult
+}
+
+func (p *pushLog) record(project Project, branch string, remote string, sha string, status string) {
+	p.recordReview(project, branch, "", remote, sha, status)
+}
+
+// recordReview notes the push of review, the review branch opened for branch.
+func (p *pushLog) recordReview(project Project, branch string, review string, remote string, sha string, status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results = append(p.results, pushResult{projectID: project.ID, project: project.Name, branch: branch, review: review, remote: remote, sha: sha, status: status})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// dockerHubRegistry is the registry API for image references without a registry host, the default for -registry.
+const dockerHubRegistry = "https://registry-1.docker.io"
+
+// manifestTypes are the manifests a registry may hold for a tag: single images and multi-arch indexes, Docker and OCI.
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// imageRefRest picks up what follows a replacement in an image reference, its tag or digest.
+var imageRefRest = regexp.MustCompile(`^[A-Za-z0-9._/:@+-]*`)
+
+// imageRefAt returns the image reference a replacement at text[start:end] ends in,
+// taking it from the last space, quote or = in the replacement through the tag after it.
+func imageRefAt(text string, start int, end int) string {
+	begin := start + strings.LastIndexAny(text[start:end], " \t\"'=") + 1
+	ref := text[begin:end] + imageRefRest.FindString(text[end:])
+	// prose may end the reference with punctuation
+	return strings.TrimRight(ref, ".,:;")
+}
+
+var errUnresolvedImage = errors.New("reference has variables, can't check it")
+
+// parseImageRef splits a reference into registry host ("" for Docker Hub), repository and tag or digest.
+func parseImageRef(ref string) (host string, repository string, reference string, err error) {
+	if strings.ContainsAny(ref, "${}") {
+		return "", "", "", errUnresolvedImage
+	}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		if reference == "" {
+			reference = name[i+1:]
+		}
+		name = name[:i]
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host, name = first, rest
+	}
+	if name == "" || reference == "" {
+		return "", "", "", fmt.Errorf("can't parse image reference %q", ref)
+	}
+	if host == "" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return host, name, reference, nil
+}
+
+// registryChecker looks image references up on their registry, remembering each answer for the rest of the run.
+type registryChecker struct {
+	base   string
+	client *http.Client
+
+	mu      sync.Mutex
+	results map[string]string
+}
+
+func newRegistryChecker(base string) *registryChecker {
+	return &registryChecker{base: strings.TrimSuffix(base, "/"), client: http.DefaultClient, results: map[string]string{}}
+}
+
+// missing returns why ref can't be pulled, or "" if its manifest is on the registry.
+func (c *registryChecker) missing(logger *log.Logger, ref string) string {
+	c.mu.Lock()
+	reason, ok := c.results[ref]
+	c.mu.Unlock()
+	if ok {
+		return reason
+	}
+	reason, err := c.lookup(ref)
+	if errors.Is(err, errUnresolvedImage) {
+		reason = err.Error()
+	} else if err != nil {
+		reason = fmt.Sprintf("check failed: %v", err)
+	}
+	if reason == "" {
+		logger.Printf("Info\tImage %s is on the registry", ref)
+	} else {
+		logger.Printf("Info\tImage %s can't be pulled: %s", ref, reason)
+	}
+	c.mu.Lock()
+	c.results[ref] = reason
+	c.mu.Unlock()
+	return reason
+}
+
+func (c *registryChecker) lookup(ref string) (string, error) {
+	host, repository, reference, err := parseImageRef(ref)
+	if err != nil {
+		return "", err
+	}
+	base := c.base
+	if host != "" {
+		base = "https://" + host
+	}
+	// HEAD answers like GET without counting against Docker Hub's pull rate limit
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", base, repository, reference)
+	token := ""
+	resp, err := c.request("HEAD", manifestURL, token)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err = c.token(resp.Header.Get("WWW-Authenticate"), repository)
+		if err != nil {
+			return "", err
+		}
+		resp, err = c.request("HEAD", manifestURL, token)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return "", nil
+	case http.StatusNotFound:
+		// a HEAD response has no error body, so ask for the tag list to tell a missing repository from a missing tag
+		tagsURL := fmt.Sprintf("%s/v2/%s/tags/list?n=1", base, repository)
+		tags, err := c.request("GET", tagsURL, token)
+		if err != nil {
+			return "", err
+		}
+		tags.Body.Close()
+		if tags.StatusCode == http.StatusOK {
+			return fmt.Sprintf("tag %s not found", reference), nil
+		}
+		return "repository not found", nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// Docker Hub answers this way for repositories that don't exist as well as private ones
+		return "repository not found or not accessible", nil
+	}
+	return "", fmt.Errorf("registry returned status %d for %s", resp.StatusCode, manifestURL)
+}
+
+func (c *registryChecker) request(method string, url string, token string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return c.client.Do(req)
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token answers a registry's Bearer challenge with a pull token for repository,
+// logging in as DOCKERHUB_USER with DOCKERHUB_TOKEN when they are set so private repositories can be seen.
+func (c *registryChecker) token(challenge string, repository string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("registry wants %q authentication, only Bearer is supported", challenge)
+	}
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry challenge %q has no realm", challenge)
+	}
+	query := url.Values{"scope": {fmt.Sprintf("repository:%s:pull", repository)}}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user := os.Getenv("DOCKERHUB_USER"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("DOCKERHUB_TOKEN"))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a registry token, status code: %d %s", resp.StatusCode, body)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		return token.AccessToken, nil
+	}
+	return token.Token, nil
+}
+
+// missingImage is a rewritten image reference the registry doesn't have.
+type missingImage struct {
+	Branch string `json:"branch"`
+	Path   string `json:"path"`
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+}
+
+// checkImages looks up every image reference the edits wrote and returns the ones that can't be pulled.
+func checkImages(logger *log.Logger, checker *registryChecker, branch string, edits []*fileEdit) []missingImage {
+	missing := []missingImage{}
+	for _, edit := range edits {
+		for _, ref := range edit.images {
+			if reason := checker.missing(logger, ref); reason != "" {
+				missing = append(missing, missingImage{Branch: branch, Path: edit.name, Image: ref, Reason: reason})
+			}
+		}
+	}
+	return missing
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry serves manifests and tag lists for the listed repositories and tags behind a Bearer token challenge,
+// like Docker Hub. Manifests only answer HEAD, so a GET, which Docker Hub counts as a pull, fails the test.
+func fakeRegistry(t *testing.T, tags map[string][]string) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("service") != "fake-registry" || !strings.HasPrefix(r.URL.Query().Get("scope"), "repository:") {
+			http.Error(w, "bad token request", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"token": "pull-token"})
+	})
+	mux.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry"`, server.URL))
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"errors": []map[string]string{{"code": "UNAUTHORIZED"}}})
+			return
+		}
+		rest := strings.TrimPrefix(r.URL.Path, "/v2/")
+		if repository, ok := strings.CutSuffix(rest, "/tags/list"); ok {
+			if known, ok := tags[repository]; ok {
+				writeJSON(w, http.StatusOK, map[string]any{"name": repository, "tags": known})
+				return
+			}
+			writeJSON(w, http.StatusNotFound, map[string]any{"errors": []map[string]string{{"code": "NAME_UNKNOWN"}}})
+			return
+		}
+		repository, reference, ok := strings.Cut(rest, "/manifests/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodHead {
+			t.Errorf("manifest %s fetched with %s, not HEAD", rest, r.Method)
+		}
+		known, ok := tags[repository]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{"errors": []map[string]string{{"code": "NAME_UNKNOWN"}}})
+			return
+		}
+		for _, tag := range known {
+			if tag == reference {
+				writeJSON(w, http.StatusOK, map[string]any{"schemaVersion": 2})
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]any{"errors": []map[string]string{{"code": "MANIFEST_UNKNOWN"}}})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestImageRefAt(t *testing.T) {
+	for _, tc := range []struct{ text, replacement, want string }{
+		{"    image: uncw-library/web:1.2\n", "image: uncw-library/web", "uncw-library/web:1.2"},
+		{"FROM uncw-library/base AS build\n", "uncw-library/base", "uncw-library/base"},
+		{"Run `docker pull uncw-library/web:latest`.", "uncw-library/web", "uncw-library/web:latest"},
+		{"See uncw-library/web.", "uncw-library/web", "uncw-library/web"},
+		{`image: "uncw-library/web@sha256:abc"`, "uncw-library/web", "uncw-library/web@sha256:abc"},
+	} {
+		start := strings.Index(tc.text, tc.replacement)
+		if got := imageRefAt(tc.text, start, start+len(tc.replacement)); got != tc.want {
+			t.Errorf("imageRefAt(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	for ref, want := range map[string][3]string{
+		"uncw-library/web:1.2":             {"", "uncw-library/web", "1.2"},
+		"uncw-library/web":                 {"", "uncw-library/web", "latest"},
+		"nginx":                            {"", "library/nginx", "latest"},
+		"ghcr.io/uncw-library/web:2":       {"ghcr.io", "uncw-library/web", "2"},
+		"localhost:5000/web":               {"localhost:5000", "web", "latest"},
+		"uncw-library/web:1@sha256:abc123": {"", "uncw-library/web", "sha256:abc123"},
+	} {
+		host, repository, reference, err := parseImageRef(ref)
+		if err != nil || [3]string{host, repository, reference} != want {
+			t.Errorf("parseImageRef(%q) = %q %q %q %v, want %q", ref, host, repository, reference, err, want)
+		}
+	}
+	if _, _, _, err := parseImageRef("uncw-library/web:${TAG}"); err != errUnresolvedImage {
+		t.Errorf("expected errUnresolvedImage, got %v", err)
+	}
+}
+
+func TestRegistryCheckerMissing(t *testing.T) {
+	registry := fakeRegistry(t, map[string][]string{"uncw-library/web": {"latest", "1.2"}})
+	checker := newRegistryChecker(registry.URL)
+	logger := log.New(io.Discard, "", 0)
+	for ref, want := range map[string]string{
+		"uncw-library/web":        "",
+		"uncw-library/web:1.2":    "",
+		"uncw-library/web:9.9":    "tag 9.9 not found",
+		"uncw-library/gone:1":     "repository not found",
+		"uncw-library/web:${TAG}": errUnresolvedImage.Error(),
+	} {
+		if got := checker.missing(logger, ref); got != want {
+			t.Errorf("missing(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestDoTheWorkImageCheck(t *testing.T) {
+	for _, policy := range []string{"flag", "block"} {
+		t.Run(policy, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			catalog, _ := catalogFixture(f)
+			before := branchTips(t, catalog.URL)
+			registry := fakeRegistry(t, map[string][]string{"uncw-library/catalog-web": {"1.0"}})
+			opts := testOptions(t, f, "go")
+			opts.pushRemote = "origin"
+			opts.imagePolicy = policy
+			opts.registry = newRegistryChecker(registry.URL)
+			j, err := openJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+			opts.journal = j
+
+			_, erroreds := doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("erroreds %v", erroreds)
+			}
+			pr := opts.report.Projects[0]
+			if len(pr.MissingImages) != 2 {
+				t.Fatalf("missing images %+v, want catalog-web:latest on both branches", pr.MissingImages)
+			}
+			for _, m := range pr.MissingImages {
+				if m.Image != "uncw-library/catalog-web:latest" || m.Path != "docker-compose.yml" || m.Reason != "tag latest not found" {
+					t.Errorf("unexpected missing image %+v", m)
+				}
+			}
+			after := branchTips(t, catalog.URL)
+			for _, branch := range []string{"main", "feature"} {
+				pushed := after[branch] != before[branch]
+				if pushed != (policy == "flag") {
+					t.Errorf("%s policy: %s pushed = %v", policy, branch, pushed)
+				}
+			}
+			if policy == "flag" {
+				return
+			}
+			for _, br := range pr.Branches {
+				if br.Status != journalBlocked {
+					t.Errorf("blocked branch %s has status %q", br.Name, br.Status)
+				}
+			}
+
+			// once the image is on the registry, -retry-failed picks the blocked branches up again
+			opts.registry = newRegistryChecker(fakeRegistry(t, map[string][]string{"uncw-library/catalog-web": {"latest"}}).URL)
+			opts.retryFailed = true
+			opts.report = newReport(opts.runID)
+			_, erroreds = doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("retry erroreds %v", erroreds)
+			}
+			retried := branchTips(t, catalog.URL)
+			for _, branch := range []string{"main", "feature"} {
+				if retried[branch] == before[branch] {
+					t.Errorf("retry did not push %s", branch)
+				}
+			}
+		})
+	}
+}
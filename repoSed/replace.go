@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// replacementFuncs are the helpers available inside a rule's replacement template.
+var replacementFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"slug":  slug,
+	// flatten turns an image path into a single Docker Hub repo name, the same way imageToDockerhub does
+	"flatten": func(s string) string { return strings.ReplaceAll(s, "/", "-") },
+	"replace": func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug lowercases s and collapses every run of other characters into a single "-".
+func slug(s string) string {
+	return strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func parseReplacement(name string, replacement string) (*template.Template, error) {
+	return template.New(name).Funcs(replacementFuncs).Option("missingkey=error").Parse(replacement)
+}
+
+// matchData exposes one match to the replacement template.
+// The whole match is "match", numbered groups are "g1", "g2"... and named groups use their own names.
+func matchData(re *regexp.Regexp, text string, loc []int) map[string]string {
+	data := map[string]string{}
+	names := re.SubexpNames()
+	for i := 0; i < len(loc)/2; i++ {
+		value := ""
+		if loc[2*i] >= 0 {
+			value = text[loc[2*i]:loc[2*i+1]]
+		}
+		if i == 0 {
+			data["match"] = value
+			continue
+		}
+		data[fmt.Sprintf("g%d", i)] = value
+		if names[i] != "" {
+			data[names[i]] = value
+		}
+	}
+	return data
+}
+
+// apply replaces every match of the rule's needle in text, expanding the replacement template for each match separately.
+// It returns the new text and where each replacement landed in it, as [start, end) offsets.
+func (r rule) apply(text string) (string, [][2]int, error) {
+	locs := r.re.FindAllStringSubmatchIndex(text, -1)
+	if len(locs) == 0 {
+		return text, nil, nil
+	}
+
+	var sb strings.Builder
+	spans := [][2]int{}
+	last := 0
+	for _, loc := range locs {
+		sb.WriteString(text[last:loc[0]])
+		start := sb.Len()
+		err := r.tmpl.Execute(&sb, matchData(r.re, text, loc))
+		if err != nil {
+			return text, nil, fmt.Errorf("error expanding replacement for rule %s: %v", r.Name, err)
+		}
+		spans = append(spans, [2]int{start, sb.Len()})
+		last = loc[1]
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), spans, nil
+}
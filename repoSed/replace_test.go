@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// oneRule loads a rule with the given needle and replacement template.
+func oneRule(t *testing.T, needle string, replacement string) rule {
+	t.Helper()
+	rules, _, err := loadRules(writeRules(t, "rules:\n  - name: r\n    files: [\"**\"]\n    needle: '"+needle+"'\n    replacement: '"+replacement+"'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules[0]
+}
+
+func TestRuleApply(t *testing.T) {
+	for _, tc := range []struct {
+		name, needle, replacement, text, want string
+	}{
+		{
+			name:        "each match gets its own replacement",
+			needle:      `registry/([a-z]+)/([a-z]+)`,
+			replacement: `hub/{{.g1}}-{{.g2}}`,
+			text:        "a: registry/dev/web\nb: registry/ops/db\n",
+			want:        "a: hub/dev-web\nb: hub/ops-db\n",
+		},
+		{
+			name:        "named groups",
+			needle:      `(?P<group>[a-z-]+)/(?P<repo>[a-z]+)\.git`,
+			replacement: `{{.repo}} from {{.group}} ({{.g2}}, {{.match}})`,
+			text:        "url: randall-dev/catalog.git",
+			want:        "url: catalog from randall-dev (catalog, randall-dev/catalog.git)",
+		},
+		{
+			name:        "unmatched optional group is empty",
+			needle:      `v(\d+)(-rc)?`,
+			replacement: `[{{.g1}}|{{.g2}}]`,
+			text:        "v1 v2-rc",
+			want:        "[1|] [2|-rc]",
+		},
+		{"lower", `[A-Z]+`, `{{lower .match}}`, "Catalog WEB", "catalog web"},
+		{"upper", `[a-z]+`, `{{upper .match}}`, "web-1", "WEB-1"},
+		{"slug", `name: (.+)`, `name: {{slug .g1}}`, "name: Special Collections / Web!", "name: special-collections-web"},
+		{"flatten", `image: (\S+)`, `image: uncw-library/{{flatten .g1}}`, "image: catalog/web/api", "image: uncw-library/catalog-web-api"},
+		{"replace", `host: (\S+)`, `host: {{replace "libapps-admin" "github" .g1}}`, "host: libapps-admin.uncw.edu", "host: github.uncw.edu"},
+		{"no match", `absent`, `x`, "nothing here", "nothing here"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, spans, err := oneRule(t, tc.needle, tc.replacement).apply(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			// the spans cover exactly the replacements
+			rest := got
+			for i := len(spans) - 1; i >= 0; i-- {
+				rest = rest[:spans[i][0]] + rest[spans[i][1]:]
+			}
+			if unmatched := oneRule(t, tc.needle, "").re.ReplaceAllString(tc.text, ""); rest != unmatched {
+				t.Errorf("spans %v leave %q, want %q", spans, rest, unmatched)
+			}
+		})
+	}
+}
+
+func TestReplacementTemplateErrors(t *testing.T) {
+	for replacement, want := range map[string]string{
+		"{{.missing}}":    `map has no entry for key "missing"`,
+		"{{.g2}}":         `map has no entry for key "g2"`,
+		"{{shout .g1}}":   `function "shout" not defined`,
+		"{{upper .g1":     "unclosed action",
+		"{{replace .g1}}": "wrong number of args",
+	} {
+		_, _, err := loadRules(writeRules(t, "rules:\n  - name: r\n    files: [\"**\"]\n    needle: (a)\n    replacement: '"+replacement+"'\n"))
+		if err == nil || !strings.Contains(err.Error(), "invalid replacement") || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want an invalid replacement error containing %q", replacement, err, want)
+		}
+	}
+}
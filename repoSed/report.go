@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// report is the machine readable record of a run, written as JSON, CSV and HTML when the run ends.
+type report struct {
+	mu       sync.Mutex
+	RunID    string           `json:"run_id"`
+	DryRun   bool             `json:"dry_run"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Projects []*projectReport `json:"projects"`
+	byID     map[int]*projectReport
+	// skipped files wait here, by journalKey, until their branch is recorded
+	skips map[string][]fileSkip
+}
+
+type projectReport struct {
+	ID       int             `json:"id"`
+	Name     string          `json:"name"`
+	Path     string          `json:"path_with_namespace"`
+	Folder   string          `json:"folder"`
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	LFSFiles int             `json:"lfs_objects,omitempty"`
+	LFSBytes int64           `json:"lfs_bytes,omitempty"`
+	Secrets  int             `json:"secret_findings,omitempty"`
+	Branches []*branchReport `json:"branches"`
+
+	MissingSubmodules []missingSubmodule `json:"missing_submodules,omitempty"`
+	MissingImages     []missingImage     `json:"missing_images,omitempty"`
+}
+
+type branchReport struct {
+	Name       string       `json:"name"`
+	Status     string       `json:"status"`
+	Reason     string       `json:"reason,omitempty"`
+	Commit     string       `json:"commit,omitempty"`
+	PushStatus string       `json:"push_status,omitempty"`
+	PushedSHA  string       `json:"pushed_sha,omitempty"`
+	Review     string       `json:"review_branch,omitempty"`
+	Files      []fileReport `json:"files"`
+	Skipped    []fileSkip   `json:"skipped_files,omitempty"`
+}
+
+type fileReport struct {
+	Path  string      `json:"path"`
+	Rules []ruleMatch `json:"rules"`
+}
+
+// fileSkip is a file that matched a rule but was deliberately not edited.
+type fileSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type ruleMatch struct {
+	Rule    string `json:"rule"`
+	Matches int    `json:"matches"`
+}
+
+func newReport(runID string) *report {
+	return &report{RunID: runID, Started: time.Now(), Projects: []*projectReport{}, byID: map[int]*projectReport{}, skips: map[string][]fileSkip{}}
+}
+
+// projectLocked finds or adds the project's entry. The caller holds r.mu.
+func (r *report) projectLocked(project Project) *projectReport {
+	pr, ok := r.byID[project.ID]
+	if !ok {
+		pr = &projectReport{ID: project.ID, Name: project.Name, Path: project.PathWithNamespace, Branches: []*branchReport{}}
+		r.byID[project.ID] = pr
+		r.Projects = append(r.Projects, pr)
+	}
+	return pr
+}
+
+// recordProject sets the project's overall status once it has finished.
+func (r *report) recordProject(project Project, folder string, status string, errText string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.projectLocked(project)
+	pr.Folder, pr.Status, pr.Error = folder, status, errText
+}
+
+// recordLFS notes how many LFS objects the project carries.
+func (r *report) recordLFS(project Project, count int, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.projectLocked(project)
+	pr.LFSFiles, pr.LFSBytes = count, size
+}
+
+// recordMissingSubmodules notes submodules on a branch whose target project is not in the inventory.
+func (r *report) recordMissingSubmodules(project Project, branch string, missing []missingSubmodule) {
+	if len(missing) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.projectLocked(project)
+	for _, m := range missing {
+		m.Branch = branch
+		pr.MissingSubmodules = append(pr.MissingSubmodules, m)
+	}
+}
+
+// recordMissingImages notes rewritten image references the registry doesn't have.
+func (r *report) recordMissingImages(project Project, missing []missingImage) {
+	if len(missing) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.projectLocked(project)
+	pr.MissingImages = append(pr.MissingImages, missing...)
+}
+
+// recordSkippedFile notes a file on a branch that was left unedited and why.
+func (r *report) recordSkippedFile(project Project, branch string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := journalKey(project.ID, branch)
+	r.skips[key] = append(r.skips[key], fileSkip{Path: name, Reason: reason})
+}
+
+// recordSecrets adds to the project's count of secret findings.
+func (r *report) recordSecrets(project Project, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.projectLocked(project)
+	pr.Secrets += count
+}
+
+// recordBranch adds a branch's outcome, with the files and rules that changed it.
+func (r *report) recordBranch(project Project, branch string, status string, reason string, edits []*fileEdit, commit string) {
+	br := &branchReport{Name: branch, Status: status, Reason: reason, Commit: commit, Files: []fileReport{}}
+	for _, edit := range edits {
+		fr := fileReport{Path: edit.name, Rules: []ruleMatch{}}
+		for name, count := range edit.matches {
+			fr.Rules = append(fr.Rules, ruleMatch{Rule: name, Matches: count})
+		}
+		sort.Slice(fr.Rules, func(i, j int) bool { return fr.Rules[i].Rule < fr.Rules[j].Rule })
+		br.Files = append(br.Files, fr)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := journalKey(project.ID, branch)
+	br.Skipped = r.skips[key]
+	delete(r.skips, key)
+	pr := r.projectLocked(project)
+	pr.Branches = append(pr.Branches, br)
+}
+
+// addPushes copies each branch's push outcome from the push log into the report.
+// A review branch's push goes on the branch it was opened for.
+func (r *report) addPushes(pushes *pushLog) {
+	for _, p := range pushes.results {
+		pr, ok := r.byID[p.projectID]
+		if !ok {
+			continue
+		}
+		for _, br := range pr.Branches {
+			if br.Name == p.branch {
+				br.PushStatus, br.PushedSHA, br.Review = p.status, p.sha, p.review
+			}
+		}
+	}
+}
+
+// writeReport writes logs/report-<runID>.json, .csv and .html.
+func writeReport(r *report, pushes *pushLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	r.addPushes(pushes)
+
+	base := path.Join("logs", fmt.Sprintf("report-%s", r.RunID))
+	writers := []struct {
+		ext   string
+		write func(*os.File) error
+	}{
+		{".json", r.writeJSON},
+		{".csv", r.writeCSV},
+		{".html", r.writeHTML},
+	}
+	for _, w := range writers {
+		filename := base + w.ext
+		file, err := os.Create(filename)
+		if err != nil {
+			log.Printf("Error\tcreating report %s: %v", filename, err)
+			continue
+		}
+		err = w.write(file)
+		file.Close()
+		if err != nil {
+			log.Printf("Error\twriting report %s: %v", filename, err)
+			continue
+		}
+		log.Printf("Report\t%s", filename)
+	}
+}
+
+func (r *report) writeJSON(file *os.File) error {
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// reportRow is one flattened line of the report: a rule's matches in one file on one branch, or a file that was skipped.
+type reportRow struct {
+	Project, Path, ProjectStatus, ProjectError, LFSObjects, LFSBytes, MissingSubmodules, MissingImages, SecretFindings, Branch, BranchStatus, BranchReason, Commit, PushStatus, PushedSHA, ReviewBranch, File, Rule, Matches, FileSkipped string
+}
+
+func (r *report) rows() []reportRow {
+	rows := []reportRow{}
+	for _, pr := range r.Projects {
+		base := reportRow{Project: pr.Name, Path: pr.Path, ProjectStatus: pr.Status, ProjectError: pr.Error}
+		if pr.LFSFiles > 0 {
+			base.LFSObjects, base.LFSBytes = strconv.Itoa(pr.LFSFiles), strconv.FormatInt(pr.LFSBytes, 10)
+		}
+		missing := []string{}
+		for _, m := range pr.MissingSubmodules {
+			missing = append(missing, fmt.Sprintf("%s:%s=%s", m.Branch, m.Name, m.URL))
+		}
+		base.MissingSubmodules = strings.Join(missing, "; ")
+		images := []string{}
+		for _, m := range pr.MissingImages {
+			images = append(images, fmt.Sprintf("%s:%s=%s (%s)", m.Branch, m.Path, m.Image, m.Reason))
+		}
+		base.MissingImages = strings.Join(images, "; ")
+		if pr.Secrets > 0 {
+			base.SecretFindings = strconv.Itoa(pr.Secrets)
+		}
+		if len(pr.Branches) == 0 {
+			rows = append(rows, base)
+			continue
+		}
+		for _, br := range pr.Branches {
+			row := base
+			row.Branch, row.BranchStatus, row.BranchReason = br.Name, br.Status, br.Reason
+			row.Commit, row.PushStatus, row.PushedSHA, row.ReviewBranch = br.Commit, br.PushStatus, br.PushedSHA, br.Review
+			if len(br.Files) == 0 && len(br.Skipped) == 0 {
+				rows = append(rows, row)
+				continue
+			}
+			for _, fr := range br.Files {
+				for _, rm := range fr.Rules {
+					fileRow := row
+					fileRow.File, fileRow.Rule, fileRow.Matches = fr.Path, rm.Rule, strconv.Itoa(rm.Matches)
+					rows = append(rows, fileRow)
+				}
+			}
+			for _, skip := range br.Skipped {
+				skipRow := row
+				skipRow.File, skipRow.FileSkipped = skip.Path, skip.Reason
+				rows = append(rows, skipRow)
+			}
+		}
+	}
+	return rows
+}
+
+func (r *report) writeCSV(file *os.File) error {
+	w := csv.NewWriter(file)
+	w.Write([]string{"project", "path_with_namespace", "project_status", "project_error", "lfs_objects", "lfs_bytes", "missing_submodules", "missing_images", "secret_findings", "branch", "branch_status", "branch_reason", "commit", "push_status", "pushed_sha", "review_branch", "file", "rule", "matches", "file_skipped"})
+	for _, row := range r.rows() {
+		w.Write([]string{row.Project, row.Path, row.ProjectStatus, row.ProjectError, row.LFSObjects, row.LFSBytes, row.MissingSubmodules, row.MissingImages, row.SecretFindings, row.Branch, row.BranchStatus, row.BranchReason, row.Commit, row.PushStatus, row.PushedSHA, row.ReviewBranch, row.File, row.Rule, row.Matches, row.FileSkipped})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>repoSed run {{.Report.RunID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 4px 6px; text-align: left; vertical-align: top; }
+th { background: #eee; position: sticky; top: 0; }
+tr.error td { background: #fdd; }
+tr.skipped td { background: #ffd; }
+code { font-size: 0.95em; }
+</style>
+</head>
+<body>
+<h1>repoSed run {{.Report.RunID}}{{if .Report.DryRun}} (dry run){{end}}</h1>
+<p>Started {{.Report.Started.Format "2006-01-02 15:04:05"}}, finished {{.Report.Finished.Format "2006-01-02 15:04:05"}}.
+{{.Counts.success}} projects succeeded, {{.Counts.error}} failed, {{.Counts.skipped}} skipped.</p>
+<table>
+<tr><th>Project</th><th>Status</th><th>LFS</th><th>Missing submodules</th><th>Missing images</th><th>Secrets</th><th>Branch</th><th>Branch status</th><th>Commit</th><th>Push</th><th>File</th><th>Rule</th><th>Matches</th><th>Skipped</th><th>Error / reason</th></tr>
+{{range .Rows}}<tr class="{{if or (eq .ProjectStatus "error") (eq .BranchStatus "failed")}}error{{else if or (eq .BranchStatus "skipped") (eq .BranchStatus "blocked")}}skipped{{end}}">
+<td title="{{.Path}}">{{.Project}}</td><td>{{.ProjectStatus}}</td><td>{{if .LFSObjects}}{{.LFSObjects}} objects, {{.LFSBytes}} bytes{{end}}</td><td>{{.MissingSubmodules}}</td><td>{{.MissingImages}}</td><td>{{.SecretFindings}}</td><td>{{.Branch}}</td><td>{{.BranchStatus}}</td>
+<td><code>{{.Commit}}</code></td><td>{{.PushStatus}}{{if .ReviewBranch}} to {{.ReviewBranch}}{{end}} <code>{{.PushedSHA}}</code></td>
+<td>{{.File}}</td><td>{{.Rule}}</td><td>{{.Matches}}</td><td>{{.FileSkipped}}</td><td>{{or .BranchReason .ProjectError}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (r *report) writeHTML(file *os.File) error {
+	counts := map[string]int{"success": 0, "error": 0, "skipped": 0}
+	for _, pr := range r.Projects {
+		counts[pr.Status]++
+	}
+	return reportHTML.Execute(file, map[string]interface{}{"Report": r, "Counts": counts, "Rows": r.rows()})
+}
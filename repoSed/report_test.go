@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// reportFixture records a run with a pushed project, a failed one and a skipped one. The first two share a name
+// in different groups, and the pushed one has a review branch.
+func reportFixture() (*report, *pushLog) {
+	r := newReport("20260101_120000")
+	pushes := &pushLog{}
+	web := Project{ID: 1, Name: "web", PathWithNamespace: "randall-dev/web"}
+	otherWeb := Project{ID: 2, Name: "web", PathWithNamespace: "special-collections/web"}
+	plain := Project{ID: 3, Name: "plain", PathWithNamespace: "randall-dev/plain"}
+
+	edit := &fileEdit{name: "docker-compose.yml", matches: map[string]int{"compose-image": 2}}
+	r.recordSkippedFile(web, "main", "logo.png", "binary content")
+	r.recordBranch(web, "main", journalCompleted, "", []*fileEdit{edit}, "c0ffee")
+	r.recordBranch(web, "dev", journalCompleted, "", []*fileEdit{edit}, "d00d")
+	r.recordProject(web, "repos/randall-dev__web", "success", "")
+	pushes.record(web, "main", "origin", "c0ffee", "pushed")
+	pushes.recordReview(web, "dev", "repoSed-migration-dev", "origin", "d00d", "pushed")
+
+	r.recordBranch(otherWeb, "main", journalFailed, "error pushing branch main", []*fileEdit{edit}, "beef")
+	r.recordProject(otherWeb, "repos/special-collections__web", "error", "error pushing branch main")
+	pushes.record(otherWeb, "main", "origin", "", "failed")
+
+	r.recordProject(plain, "repos/randall-dev__plain", journalSkipped, "journal has nothing left to do")
+	return r, pushes
+}
+
+func TestWriteReport(t *testing.T) {
+	inTempDir(t)
+	if err := os.Mkdir("logs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	r, pushes := reportFixture()
+	writeReport(r, pushes)
+
+	data, err := os.ReadFile("logs/report-20260101_120000.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Projects) != 3 {
+		t.Fatalf("projects %+v", got.Projects)
+	}
+	web, otherWeb, plain := got.Projects[0], got.Projects[1], got.Projects[2]
+	if web.Status != "success" || len(web.Branches) != 2 || web.Branches[0].Skipped[0].Path != "logo.png" {
+		t.Errorf("web %+v", web)
+	}
+	// same-named projects keep their own push results
+	if br := web.Branches[0]; br.PushStatus != "pushed" || br.PushedSHA != "c0ffee" || br.Review != "" {
+		t.Errorf("web main %+v", br)
+	}
+	if br := web.Branches[1]; br.PushStatus != "pushed" || br.Review != "repoSed-migration-dev" {
+		t.Errorf("web dev %+v", br)
+	}
+	if br := otherWeb.Branches[0]; otherWeb.Status != "error" || br.Status != journalFailed || br.PushStatus != "failed" || br.PushedSHA != "" {
+		t.Errorf("special-collections/web %+v %+v", otherWeb, br)
+	}
+	if plain.Status != journalSkipped || len(plain.Branches) != 0 {
+		t.Errorf("plain %+v", plain)
+	}
+
+	file, err := os.Open("logs/report-20260101_120000.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	column := map[string]int{}
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	rows := []string{}
+	for _, record := range records[1:] {
+		rows = append(rows, strings.Join([]string{record[column["path_with_namespace"]], record[column["branch"]], record[column["branch_status"]],
+			record[column["push_status"]], record[column["review_branch"]], record[column["file"]], record[column["matches"]], record[column["file_skipped"]]}, "|"))
+	}
+	want := []string{
+		"randall-dev/web|main|completed|pushed||docker-compose.yml|2|",
+		"randall-dev/web|main|completed|pushed||logo.png||binary content",
+		"randall-dev/web|dev|completed|pushed|repoSed-migration-dev|docker-compose.yml|2|",
+		"special-collections/web|main|failed|failed||docker-compose.yml|2|",
+		"randall-dev/plain|||||||",
+	}
+	if strings.Join(rows, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CSV rows:\n%s\nwant:\n%s", strings.Join(rows, "\n"), strings.Join(want, "\n"))
+	}
+
+	html, err := os.ReadFile("logs/report-20260101_120000.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"1 projects succeeded, 1 failed, 1 skipped.",
+		`<tr class="error">`,
+		`<td title="special-collections/web">web</td>`,
+		"pushed to repoSed-migration-dev",
+		"journal has nothing left to do",
+	} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("HTML is missing %q:\n%s", want, html)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// reviewBranchPrefix names the branches review mode commits to instead of the project's own branches.
+const reviewBranchPrefix = "migration/update-references"
+
+// reviewBranchName is the branch holding the rewrites for branch.
+// The default branch gets the plain prefix; other branches get a suffix so each has its own review.
+func reviewBranchName(branch Branch) string {
+	if branch.Default {
+		return reviewBranchPrefix
+	}
+	return fmt.Sprintf("%s-%s", reviewBranchPrefix, slug(branch.Name))
+}
+
+// openReview commits the edits onto a review branch cut from branch, pushes it,
+// and opens a GitLab merge request or GitHub pull request back into branch.
+// It returns the review branch's new commit.
+func openReview(logger *log.Logger, folder string, project Project, branch Branch, edits []*fileEdit, opts *options) (commit string, err error) {
+	if len(edits) == 0 {
+		logger.Printf("Info\tNo edits on %s, not opening a review", branch.Name)
+		return "", nil
+	}
+	review := Branch{Name: reviewBranchName(branch)}
+	// gitClient only checks out existing branches, so the git binary creates or resets the review branch
+	_, err = runCommand(logger, folder, "git", "checkout", "-B", review.Name)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return "", fmt.Errorf("error creating branch %s in folder %s", review.Name, folder)
+	}
+	commit, err = commitChanges(logger, folder, project, branch, edits, opts)
+	if err != nil {
+		return "", err
+	}
+	err = blockPushForSecrets(logger, project, branch, opts)
+	if err != nil {
+		return commit, err
+	}
+	sha, err := pushBranch(logger, folder, review, opts.pushRemote, project.LFS, opts.git)
+	if err != nil {
+		opts.pushes.recordReview(project, branch.Name, review.Name, opts.pushRemote, "", "failed")
+		return commit, err
+	}
+	opts.pushes.recordReview(project, branch.Name, review.Name, opts.pushRemote, sha, "pushed")
+
+	title := fmt.Sprintf("Update references for the GitHub migration (%s)", branch.Name)
+	description := reviewDescription(edits, opts.rules)
+	var url string
+	if opts.pushRemote == "github" {
+		url, err = openPullRequest(project, review.Name, branch.Name, title, description)
+	} else {
+		url, err = openMergeRequest(opts.apiURL, project, review.Name, branch.Name, title, description)
+	}
+	if err != nil {
+		return commit, fmt.Errorf("error opening review for %s in %s: %v", branch.Name, project.Name, err)
+	}
+	logger.Printf("Review\t%s\t%s\t%s", project.Name, branch.Name, url)
+	return commit, nil
+}
+
+// reviewDescription lists each rule that fired with the files it changed.
+func reviewDescription(edits []*fileEdit, rules []rule) string {
+	filesByRule := map[string][]string{}
+	countByRule := map[string]int{}
+	for _, edit := range edits {
+		for name, count := range edit.matches {
+			filesByRule[name] = append(filesByRule[name], edit.name)
+			countByRule[name] += count
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Automated reference rewrite from repoSed for the move from libapps-admin.uncw.edu to GitHub and Docker Hub.\n\n")
+	sb.WriteString("| Rule | Description | Replacements | Files |\n|---|---|---|---|\n")
+	for _, r := range rules {
+		files, ok := filesByRule[r.Name]
+		if !ok {
+			continue
+		}
+		sort.Strings(files)
+		fmt.Fprintf(&sb, "| `%s` | %s | %d | %s |\n", r.Name, r.Description, countByRule[r.Name], "`"+strings.Join(files, "`, `")+"`")
+	}
+	return sb.String()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// migrationTrailer is the commit trailer repoSed adds to its rewrite commits, holding the run ID.
+const migrationTrailer = "Migration-Run"
+
+// legacyCommitGrep matches rewrite commits made before repoSed added the trailer.
+const legacyCommitGrep = `^"?Updating git & image references"?$`
+
+type rollbackResult struct {
+	project  string
+	branch   string
+	reverted []string
+	status   string
+}
+
+// rollbackLog keeps the outcome of every branch a rollback looked at.
+type rollbackLog struct {
+	mu      sync.Mutex
+	results []rollbackResult
+}
+
+func (r *rollbackLog) record(project string, branch string, reverted []string, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, rollbackResult{project: project, branch: branch, reverted: reverted, status: status})
+}
+
+// findMigrationCommits lists the not yet reverted repoSed commits on the checked out branch, newest first.
+// With runID set, only that run's commits are returned. It searches with the git binary, like the reverts do,
+// since go-git has neither log --grep nor revert.
+func findMigrationCommits(logger *log.Logger, folder string, runID string) ([]string, error) {
+	args := []string{"log", "--format=%H", "-E"}
+	if runID != "" {
+		args = append(args, fmt.Sprintf("--grep=^%s: %s$", migrationTrailer, runID))
+	} else {
+		args = append(args, fmt.Sprintf("--grep=^%s: ", migrationTrailer), "--grep="+legacyCommitGrep)
+	}
+	output, err := runCommand(logger, folder, "git", args...)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return nil, fmt.Errorf("error searching for migration commits in folder %s", folder)
+	}
+
+	commits := []string{}
+	for _, sha := range strings.Fields(output) {
+		reverts, err := runCommand(logger, folder, "git", "log", "--format=%H", "-F", fmt.Sprintf("--grep=This reverts commit %s.", sha))
+		if err != nil {
+			logger.Printf("Error\t%v", err)
+			return nil, fmt.Errorf("error searching for reverts of %s in folder %s", sha, folder)
+		}
+		if reverts != "" {
+			logger.Printf("Info\t%s is already reverted by %s", sha, reverts)
+			continue
+		}
+		commits = append(commits, sha)
+	}
+	return commits, nil
+}
+
+// rollbackBranch reverts the repoSed commits on branch, newest first, in a worktree of its own,
+// then pushes if a push remote is set.
+func rollbackBranch(logger *log.Logger, clone string, project Project, branch Branch, opts *options) error {
+	folder, err := addWorktree(logger, clone, branch)
+	if err != nil {
+		return err
+	}
+	defer removeWorktree(logger, clone, folder)
+	err = gitFetchPull(logger, folder, opts.git)
+	if err != nil {
+		return err
+	}
+
+	commits, err := findMigrationCommits(logger, folder, opts.rollbackRun)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		opts.rollbacks.record(project.Name, branch.Name, nil, "no migration commit")
+		return nil
+	}
+	head, err := opts.git.head(logger, folder)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error reading HEAD in folder %s", folder)
+	}
+	status := "reverted"
+	if commits[0] != head {
+		// later work landed on top; the revert may still apply cleanly, but someone should look
+		status = "reverted, migration commit was not at the tip"
+	}
+	if opts.dryRun {
+		opts.rollbacks.record(project.Name, branch.Name, commits, "would revert"+strings.TrimPrefix(status, "reverted"))
+		return nil
+	}
+
+	for _, sha := range commits {
+		args := append(gitIdentityArgs(opts), "revert", "--no-edit", sha)
+		_, err := runCommand(logger, folder, "git", args...)
+		if err != nil {
+			logger.Printf("Error\t%v", err)
+			_, abortErr := runCommand(logger, folder, "git", "revert", "--abort")
+			if abortErr != nil {
+				logger.Printf("Error\t%v", abortErr)
+			}
+			opts.rollbacks.record(project.Name, branch.Name, nil, fmt.Sprintf("revert of %s failed", sha))
+			return fmt.Errorf("error reverting %s on branch %s in folder %s", sha, branch.Name, folder)
+		}
+	}
+
+	if opts.pushRemote != "" {
+		if branch.Protected && !opts.allowProtected {
+			opts.pushes.record(project, branch.Name, opts.pushRemote, "", "refused: protected branch")
+			status += ", not pushed: protected branch"
+		} else {
+			sha, err := pushBranch(logger, folder, branch, opts.pushRemote, project.LFS, opts.git)
+			if err != nil {
+				opts.pushes.record(project, branch.Name, opts.pushRemote, "", "failed")
+				opts.rollbacks.record(project.Name, branch.Name, commits, status+", push failed")
+				return err
+			}
+			opts.pushes.record(project, branch.Name, opts.pushRemote, sha, "pushed")
+			status += ", pushed"
+		}
+	}
+	opts.rollbacks.record(project.Name, branch.Name, commits, status)
+	return nil
+}
+
+// rollbackFolder reverts the repoSed commits on every branch of the project.
+func rollbackFolder(logger *log.Logger, folder string, project Project, opts *options) error {
+	logger.Printf("Starting\trollback in folder: %v", folder)
+	err := gitClone(logger, folder, project, opts.git)
+	if err != nil {
+		return err
+	}
+	err = setupRemote(logger, folder, project, opts.pushRemote, opts)
+	if err != nil {
+		return err
+	}
+	// every branch gets its own worktree, so the clone itself holds none of them
+	err = detachHead(logger, folder)
+	if err != nil {
+		return err
+	}
+
+	var defaultBranch Branch
+	for _, branch := range project.Branches {
+		if branch.Default {
+			defaultBranch = branch
+		}
+		err = rollbackBranch(logger, folder, project, branch, opts)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Printf("Returning to starting branch: %s\n", defaultBranch.Name)
+	return checkoutBranch(logger, folder, defaultBranch, opts.git)
+}
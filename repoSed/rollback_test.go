@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// migrate runs a rewrite of the catalog fixture that pushes to origin, returning the fake GitLab, the options for
+// the rollback and the branch tips from before and after the rewrite.
+func migrate(t *testing.T, gitImpl string) (f *fakeGitLab, opts *options, catalog Project, before map[string]string, after map[string]string) {
+	t.Helper()
+	f = newFakeGitLab(t)
+	catalog, _ = catalogFixture(f)
+	before = branchTips(t, catalog.URL)
+	opts = testOptions(t, f, gitImpl)
+	opts.pushRemote = "origin"
+	_, erroreds := doTheWork(opts)
+	if len(erroreds) != 0 {
+		t.Fatalf("rewrite erroreds %v", erroreds)
+	}
+	after = branchTips(t, catalog.URL)
+
+	opts.rollback = true
+	opts.pushes = &pushLog{}
+	opts.report = newReport(opts.runID)
+	return f, opts, catalog, before, after
+}
+
+// rollbackStatuses maps project/branch to the status rollbackBranch recorded.
+func rollbackStatuses(opts *options) map[string]string {
+	statuses := map[string]string{}
+	for _, r := range opts.rollbacks.results {
+		statuses[r.project+"/"+r.branch] = r.status
+	}
+	return statuses
+}
+
+func TestRollbackRevertsMigrationCommits(t *testing.T) {
+	for _, gitImpl := range []string{"go", "exec"} {
+		t.Run(gitImpl, func(t *testing.T) {
+			_, opts, catalog, before, after := migrate(t, gitImpl)
+
+			_, erroreds := doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("erroreds %v", erroreds)
+			}
+			reverted := branchTips(t, catalog.URL)
+			for _, branch := range []string{"main", "feature"} {
+				if parent := gitRun(t, catalog.URL, "rev-parse", branch+"^"); reverted[branch] == after[branch] || parent != after[branch] {
+					t.Errorf("%s: no revert on top of the migration commit", branch)
+				}
+				if diff := gitRun(t, catalog.URL, "diff", before[branch], branch); diff != "" {
+					t.Errorf("%s differs from before the migration:\n%s", branch, diff)
+				}
+				message := gitRun(t, catalog.URL, "log", "-1", "--format=%B", branch)
+				if !strings.Contains(message, "This reverts commit "+after[branch]) {
+					t.Errorf("%s: unexpected revert message %q", branch, message)
+				}
+			}
+			statuses := rollbackStatuses(opts)
+			for _, key := range []string{"catalog/main", "catalog/feature"} {
+				if statuses[key] != "reverted, pushed" {
+					t.Errorf("%s: status %q", key, statuses[key])
+				}
+			}
+			if statuses["plain/main"] != "no migration commit" {
+				t.Errorf("plain/main: status %q", statuses["plain/main"])
+			}
+			// each branch is reverted in a worktree of its own, removed once it's done
+			for _, p := range opts.report.Projects {
+				if worktrees := gitRun(t, p.Folder, "worktree", "list"); strings.Count(worktrees, "\n") != 0 {
+					t.Errorf("%s has worktrees left:\n%s", p.Name, worktrees)
+				}
+			}
+
+			// the reverts are found, so a second rollback has nothing to do
+			opts.rollbacks = &rollbackLog{}
+			_, erroreds = doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("second rollback erroreds %v", erroreds)
+			}
+			for branch, sha := range branchTips(t, catalog.URL) {
+				if reverted[branch] != sha {
+					t.Errorf("second rollback moved %s", branch)
+				}
+			}
+			if status := rollbackStatuses(opts)["catalog/main"]; status != "no migration commit" {
+				t.Errorf("second rollback: status %q", status)
+			}
+		})
+	}
+}
+
+func TestRollbackOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		setup  func(t *testing.T, f *fakeGitLab, opts *options, catalog Project)
+		moves  bool
+		status string
+	}{
+		{
+			name:   "dry run",
+			setup:  func(t *testing.T, f *fakeGitLab, opts *options, catalog Project) { opts.dryRun = true },
+			status: "would revert",
+		},
+		{
+			name: "other run",
+			setup: func(t *testing.T, f *fakeGitLab, opts *options, catalog Project) {
+				opts.rollbackRun = "20250101_000000"
+			},
+			status: "no migration commit",
+		},
+		{
+			name:   "this run",
+			setup:  func(t *testing.T, f *fakeGitLab, opts *options, catalog Project) { opts.rollbackRun = opts.runID },
+			moves:  true,
+			status: "reverted, pushed",
+		},
+		{
+			name:   "no push",
+			setup:  func(t *testing.T, f *fakeGitLab, opts *options, catalog Project) { opts.pushRemote = "" },
+			status: "reverted",
+		},
+		{
+			name: "protected",
+			setup: func(t *testing.T, f *fakeGitLab, opts *options, catalog Project) {
+				f.protect(catalog, "main")
+			},
+			status: "reverted, not pushed: protected branch",
+		},
+		{
+			name: "later work",
+			setup: func(t *testing.T, f *fakeGitLab, opts *options, catalog Project) {
+				work := filepath.Join(t.TempDir(), "work")
+				gitRun(t, ".", "clone", "-q", "-b", "main", catalog.URL, work)
+				if err := os.WriteFile(filepath.Join(work, "later.txt"), []byte("later\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				gitRun(t, work, "add", "later.txt")
+				gitRun(t, work, "commit", "-q", "-m", "Later work")
+				gitRun(t, work, "push", "-q", "origin", "main")
+			},
+			moves:  true,
+			status: "reverted, migration commit was not at the tip, pushed",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, opts, catalog, _, _ := migrate(t, "go")
+			tc.setup(t, f, opts, catalog)
+			tips := branchTips(t, catalog.URL)
+
+			_, erroreds := doTheWork(opts)
+			if len(erroreds) != 0 {
+				t.Fatalf("erroreds %v", erroreds)
+			}
+			if moved := branchTips(t, catalog.URL)["main"] != tips["main"]; moved != tc.moves {
+				t.Errorf("main moved = %v, want %v", moved, tc.moves)
+			}
+			if status := rollbackStatuses(opts)["catalog/main"]; status != tc.status {
+				t.Errorf("status %q, want %q", status, tc.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rule is one needle/replacement pair applied to the files it targets.
+// Files are glob patterns relative to the repo root; "**" matches any number of directories.
+// Replacement is a text/template expanded once per match; see matchData for the fields it can use.
+type rule struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Files       []string `yaml:"files"`
+	Needle      string   `yaml:"needle"`
+	Replacement string   `yaml:"replacement"`
+	Enabled     *bool    `yaml:"enabled"`
+	// Image marks a replacement that ends in an image reference, so -image-check can look it up on the registry.
+	Image bool `yaml:"image"`
+
+	re   *regexp.Regexp
+	tmpl *template.Template
+}
+
+type ruleFile struct {
+	Exclude []string        `yaml:"exclude"`
+	Verify  []verifyPattern `yaml:"verify"`
+	Rules   []rule          `yaml:"rules"`
+}
+
+// parseRuleFile decodes a YAML or JSON rule file, rejecting keys it doesn't know,
+// so a misspelled key like "enable" fails instead of being ignored.
+func parseRuleFile(filename string) (*ruleFile, error) {
+	fileBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rules file %s: %v", filename, err)
+	}
+	var rf ruleFile
+	decoder := yaml.NewDecoder(bytes.NewReader(fileBytes))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&rf); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing rules file %s: %v", filename, err)
+	}
+	return &rf, nil
+}
+
+func (r rule) enabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// loadRules reads a YAML or JSON rule file and returns its enabled rules with compiled needles,
+// plus any extra exclude globs listed in the file.
+func loadRules(filename string) (rules []rule, excludes []string, err error) {
+	rf, err := parseRuleFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rf.Rules) == 0 {
+		return nil, nil, fmt.Errorf("rules file %s has no rules", filename)
+	}
+	for _, pattern := range rf.Exclude {
+		if err := validateGlob(pattern); err != nil {
+			return nil, nil, fmt.Errorf("rules file %s: exclude %q: %v", filename, pattern, err)
+		}
+	}
+
+	rules = []rule{}
+	for i, r := range rf.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := r.validate(); err != nil {
+			return nil, nil, fmt.Errorf("rules file %s: rule %d (%s): %v", filename, i+1, r.Name, err)
+		}
+		if !r.enabled() {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, rf.Exclude, nil
+}
+
+func (r *rule) validate() error {
+	if r.Needle == "" {
+		return fmt.Errorf("needle is empty")
+	}
+	if len(r.Files) == 0 {
+		return fmt.Errorf("no files to target")
+	}
+	for _, pattern := range r.Files {
+		if err := validateGlob(pattern); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+	}
+	re, err := regexp.Compile(r.Needle)
+	if err != nil {
+		return fmt.Errorf("invalid needle %q: %v", r.Needle, err)
+	}
+	r.re = re
+
+	tmpl, err := parseReplacement(r.Name, r.Replacement)
+	if err != nil {
+		return fmt.Errorf("invalid replacement %q: %v", r.Replacement, err)
+	}
+	r.tmpl = tmpl
+	// expand once against empty groups so unknown group names fail here instead of mid-run
+	emptyMatch := make([]int, 2*(re.NumSubexp()+1))
+	if err := tmpl.Execute(io.Discard, matchData(re, "", emptyMatch)); err != nil {
+		return fmt.Errorf("invalid replacement %q: %v", r.Replacement, err)
+	}
+	return nil
+}
+
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, excludes, err := loadRules(writeRules(t, `
+exclude: ["vendor/**"]
+rules:
+  - name: host
+    files: ["**/*.md"]
+    needle: old\.example
+    replacement: new.example
+  - name: off
+    files: ["*"]
+    needle: x
+    enabled: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "host" || len(excludes) != 1 {
+		t.Errorf("rules %+v, excludes %v", rules, excludes)
+	}
+	if _, _, err := loadRules(writeRules(t, `{"rules": [{"files": ["*"], "needle": "a", "replacement": "b"}]}`)); err != nil {
+		t.Errorf("JSON rules file: %v", err)
+	}
+}
+
+func TestLoadRulesRejects(t *testing.T) {
+	for name, tc := range map[string]struct{ content, want string }{
+		"misspelled key": {`
+rules:
+  - files: ["*"]
+    needle: x
+    enable: false
+`, "field enable not found"},
+		"unknown top-level key": {"rule:\n  - needle: x\n", "field rule not found"},
+		"empty file":            {"", "has no rules"},
+		"bad needle":            {"rules:\n  - files: [\"*\"]\n    needle: \"(\"\n", "invalid needle"},
+		"no files":              {"rules:\n  - needle: x\n", "no files to target"},
+	} {
+		_, _, err := loadRules(writeRules(t, tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secretDetector finds one kind of credential. The secret is the first capture group, or the whole match.
+type secretDetector struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// secretDetectors are the credential formats scanLine reports by name; anything else random enough is "high-entropy".
+var secretDetectors = []secretDetector{
+	{"gitlab-pat", regexp.MustCompile(`\bglpat-[0-9A-Za-z_-]{20,}`)},
+	{"dockerhub-token", regexp.MustCompile(`\bdckr_pat_[0-9A-Za-z_-]{20,}`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+}
+
+// entropyToken picks out candidates for the high-entropy detector; shorter strings are too noisy to judge.
+var entropyToken = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+
+// entropyThreshold is the Shannon entropy, in bits per character, above which a token looks random.
+const entropyThreshold = 4.5
+
+// entropySkips are files full of hashes and minified data, where the entropy detector only makes noise.
+var entropySkips = []string{
+	"**/package-lock.json", "**/yarn.lock", "**/pnpm-lock.yaml", "**/composer.lock", "**/Gemfile.lock",
+	"**/poetry.lock", "**/go.sum", "**/*.min.js", "**/*.min.css", "**/*.map", "**/*.svg",
+}
+
+// secretFinding is one suspected secret. Only a redacted form of the secret is kept.
+type secretFinding struct {
+	Project     string `json:"project"`
+	Commit      string `json:"commit"`
+	Path        string `json:"path"`
+	Line        int    `json:"line"`
+	Detector    string `json:"detector"`
+	Fingerprint string `json:"fingerprint"`
+	Redacted    string `json:"redacted"`
+}
+
+// secretAllowlist holds the known false positives read from -secrets-allowlist.
+type secretAllowlist struct {
+	paths        []string
+	matches      []*regexp.Regexp
+	fingerprints map[string]bool
+}
+
+// loadAllowlist reads an allowlist file. Each line is "path:<glob>", "match:<regexp>" or a finding's fingerprint;
+// blank lines and # comments are ignored.
+func loadAllowlist(filename string) (*secretAllowlist, error) {
+	allow := &secretAllowlist{fingerprints: map[string]bool{}}
+	if filename == "" {
+		return allow, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening secrets allowlist %s, %v", filename, err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "path:"):
+			glob := strings.TrimSpace(strings.TrimPrefix(line, "path:"))
+			err = validateGlob(glob)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", filename, n, err)
+			}
+			allow.paths = append(allow.paths, glob)
+		case strings.HasPrefix(line, "match:"):
+			re, err := regexp.Compile(strings.TrimSpace(strings.TrimPrefix(line, "match:")))
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", filename, n, err)
+			}
+			allow.matches = append(allow.matches, re)
+		default:
+			allow.fingerprints[line] = true
+		}
+	}
+	return allow, scanner.Err()
+}
+
+// allows reports whether the allowlist covers a secret found in the named file.
+func (a *secretAllowlist) allows(name string, detector string, secret string) bool {
+	if a == nil {
+		return false
+	}
+	if matchesAny(a.paths, name) || a.fingerprints[secretFingerprint(detector, secret)] {
+		return true
+	}
+	for _, re := range a.matches {
+		if re.MatchString(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretFingerprint identifies a secret without revealing it, so the same secret in many commits shares one allowlist entry.
+func secretFingerprint(detector string, secret string) string {
+	sum := sha256.Sum256([]byte(detector + ":" + secret))
+	return detector + ":" + hex.EncodeToString(sum[:8])
+}
+
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", 8)
+}
+
+// shannonEntropy is the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// scanLine returns the findings on one line of the named file, leaving out allowlisted ones.
+func scanLine(name string, line string, lineNo int, allow *secretAllowlist) []secretFinding {
+	findings := []secretFinding{}
+	add := func(detector string, secret string) {
+		if allow.allows(name, detector, secret) {
+			return
+		}
+		findings = append(findings, secretFinding{Path: name, Line: lineNo, Detector: detector,
+			Fingerprint: secretFingerprint(detector, secret), Redacted: redact(secret)})
+	}
+	found := false
+	for _, d := range secretDetectors {
+		for _, m := range d.re.FindAllStringSubmatch(line, -1) {
+			secret := m[0]
+			if len(m) > 1 {
+				secret = m[1]
+			}
+			add(d.name, secret)
+			found = true
+		}
+	}
+	if found || matchesAny(entropySkips, name) {
+		return findings
+	}
+	for _, token := range entropyToken.FindAllString(line, -1) {
+		// long hex strings are nearly always hashes or commit IDs
+		if strings.Trim(strings.ToLower(token), "0123456789abcdef") == "" {
+			continue
+		}
+		if shannonEntropy(token) >= entropyThreshold {
+			add("high-entropy", token)
+		}
+	}
+	return findings
+}
+
+// scanAdded scans only the lines of after that aren't in before, so a rewrite is charged with the secrets it wrote
+// and not again with the ones its file already had. Findings in known, a set of fingerprints, are left out too.
+func scanAdded(name string, before string, after string, allow *secretAllowlist, known map[string]bool) []secretFinding {
+	old := map[string]bool{}
+	for _, line := range strings.Split(before, "\n") {
+		old[line] = true
+	}
+	findings := []secretFinding{}
+	for i, line := range strings.Split(after, "\n") {
+		if old[line] {
+			continue
+		}
+		for _, f := range scanLine(name, line, i+1, allow) {
+			if !known[f.Fingerprint] {
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings
+}
+
+// fingerprints returns the set of the findings' fingerprints.
+func fingerprints(findings []secretFinding) map[string]bool {
+	set := map[string]bool{}
+	for _, f := range findings {
+		set[f.Fingerprint] = true
+	}
+	return set
+}
+
+// hunkHeader reads the new-file start line from a "@@ -a,b +c,d @@" diff header.
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)`)
+
+// scanHistory scans every line ever added on any ref of the clone at folder, so secrets that were
+// committed and later deleted are found too. Binary files are skipped, since git log shows no lines for them.
+func scanHistory(logger *log.Logger, folder string, allow *secretAllowlist) ([]secretFinding, error) {
+	logger.Printf("Info\tScanning the history of %s for secrets", folder)
+	cmd := exec.Command("git", "log", "--all", "-p", "-U0", "--no-color", "--no-ext-diff", "--no-renames", "--format=commit %H")
+	cmd.Dir = folder
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error starting git log in %s, %v", folder, err)
+	}
+
+	findings := []secretFinding{}
+	commit, name, lineNo, previous := "", "", 0, ""
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "commit "):
+			commit = strings.TrimPrefix(line, "commit ")
+		case strings.HasPrefix(line, "+++ ") && strings.HasPrefix(previous, "--- "):
+			name = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if unquoted, uerr := strconv.Unquote(name); uerr == nil {
+				name = strings.TrimPrefix(unquoted, "b/")
+			}
+		case strings.HasPrefix(line, "@@ "):
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				lineNo, _ = strconv.Atoi(m[1])
+			}
+		case strings.HasPrefix(line, "+"):
+			for _, f := range scanLine(name, line[1:], lineNo, allow) {
+				f.Commit = commit
+				findings = append(findings, f)
+			}
+			lineNo++
+		}
+		if err == io.EOF {
+			break
+		}
+		previous = line
+	}
+	err = cmd.Wait()
+	if err != nil {
+		logger.Printf("Error\t%s", stderr.String())
+		return nil, fmt.Errorf("error reading history in %s, %v", folder, err)
+	}
+	return findings, nil
+}
+
+// blockPushForSecrets refuses a branch's push when the project has secret findings.
+func blockPushForSecrets(logger *log.Logger, project Project, branch Branch, opts *options) error {
+	if project.SecretFindings == 0 {
+		return nil
+	}
+	logger.Printf("Info\tNot pushing %s: %d secret findings", branch.Name, project.SecretFindings)
+	opts.pushes.record(project, branch.Name, opts.pushRemote, "", fmt.Sprintf("blocked: %d secret findings", project.SecretFindings))
+	return &skipError{reason: "push blocked by secret findings", blocked: true}
+}
+
+// secretLog collects every finding of a run for the findings report.
+type secretLog struct {
+	mu       sync.Mutex
+	findings []secretFinding
+}
+
+func (s *secretLog) record(project string, findings []secretFinding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range findings {
+		f.Project = project
+		s.findings = append(s.findings, f)
+	}
+}
+
+// write saves the findings as logs/secrets-<runID>.json and .csv.
+func (s *secretLog) write(runID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base := path.Join("logs", fmt.Sprintf("secrets-%s", runID))
+
+	jsonFile, err := os.Create(base + ".json")
+	if err != nil {
+		log.Printf("Error\tcreating findings report: %v", err)
+		return
+	}
+	encoder := json.NewEncoder(jsonFile)
+	encoder.SetIndent("", "  ")
+	findings := s.findings
+	if findings == nil {
+		findings = []secretFinding{}
+	}
+	err = encoder.Encode(findings)
+	jsonFile.Close()
+	if err != nil {
+		log.Printf("Error\twriting findings report: %v", err)
+	}
+
+	csvFile, err := os.Create(base + ".csv")
+	if err != nil {
+		log.Printf("Error\tcreating findings report: %v", err)
+		return
+	}
+	defer csvFile.Close()
+	w := csv.NewWriter(csvFile)
+	w.Write([]string{"project", "commit", "path", "line", "detector", "fingerprint", "redacted"})
+	for _, f := range findings {
+		w.Write([]string{f.Project, f.Commit, f.Path, strconv.Itoa(f.Line), f.Detector, f.Fingerprint, f.Redacted})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Error\twriting findings report: %v", err)
+	}
+	log.Printf("Secrets\t%d findings in %s.json", len(findings), base)
+}
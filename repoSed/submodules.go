@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const libappsHost = "libapps-admin.uncw.edu"
+
+var (
+	submoduleHeader = regexp.MustCompile(`^\s*\[submodule\s+"(.*)"\]\s*$`)
+	submoduleURL    = regexp.MustCompile(`^(\s*url\s*=\s*)(\S+)(\s*)$`)
+)
+
+// missingSubmodule is a submodule whose libapps-admin target is not in the project inventory.
+type missingSubmodule struct {
+	Branch string `json:"branch"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+}
+
+// githubRepoURL is where a project lives once it has moved to GitHub.
+func githubRepoURL(name string) string {
+	return fmt.Sprintf("https://github.com/%s/%s.git", githubOrg, name)
+}
+
+// projectInventory indexes the fetched projects by lowercased path_with_namespace.
+func projectInventory(projects []Project) map[string]Project {
+	inventory := map[string]Project{}
+	for _, p := range projects {
+		inventory[strings.ToLower(p.PathWithNamespace)] = p
+	}
+	return inventory
+}
+
+// libappsRepoPath returns the path_with_namespace an absolute URL points at on libapps-admin.
+func libappsRepoPath(raw string) (string, bool) {
+	var repoPath string
+	if strings.HasPrefix(raw, "git@"+libappsHost+":") {
+		repoPath = strings.TrimPrefix(raw, "git@"+libappsHost+":")
+	} else {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() != libappsHost {
+			return "", false
+		}
+		repoPath = u.Path
+	}
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	return repoPath, repoPath != ""
+}
+
+// mapSubmoduleURL returns the GitHub counterpart of a submodule URL.
+// relevant is false for URLs that do not point at libapps-admin; found is false when the target is not in the inventory.
+// Relative URLs stay relative, since every project lands in the same GitHub organization.
+func mapSubmoduleURL(project Project, inventory map[string]Project, raw string) (mapped string, relevant bool, found bool) {
+	if strings.HasPrefix(raw, "./") || strings.HasPrefix(raw, "../") {
+		// relative URLs resolve against the superproject's own URL
+		target := strings.TrimSuffix(path.Join(project.PathWithNamespace, raw), ".git")
+		p, ok := inventory[strings.ToLower(target)]
+		if !ok {
+			return raw, true, false
+		}
+		return "../" + p.Name + ".git", true, true
+	}
+	target, ok := libappsRepoPath(raw)
+	if !ok {
+		return raw, false, false
+	}
+	p, ok := inventory[strings.ToLower(target)]
+	if !ok {
+		return raw, true, false
+	}
+	return githubRepoURL(p.Name), true, true
+}
+
+// rewriteSubmodules points the .gitmodules URLs at GitHub and syncs them into .git/config.
+// It returns nil if there is no .gitmodules or nothing changed, plus the submodules it could not map.
+func rewriteSubmodules(logger *log.Logger, folder string, project Project, inventory map[string]Project, dryRun bool) (*fileEdit, []missingSubmodule, error) {
+	fullpath := filepath.Join(folder, ".gitmodules")
+	info, err := os.Stat(fullpath)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fileBytes, err := os.ReadFile(fullpath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	edit, missing := rewriteGitmodules(project, inventory, string(fileBytes))
+	if edit == nil || dryRun {
+		return edit, missing, nil
+	}
+	err = os.WriteFile(fullpath, []byte(edit.after), info.Mode().Perm())
+	if err != nil {
+		return nil, missing, err
+	}
+	logger.Printf("Rewrote %d submodule URLs in %s", edit.matches["submodules"], fullpath)
+	_, err = runCommand(logger, folder, "git", "submodule", "sync", "--recursive")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return nil, missing, fmt.Errorf("error syncing submodule URLs in folder %s", folder)
+	}
+	return edit, missing, nil
+}
+
+// rewriteGitmodules points the URLs in a .gitmodules file's text at GitHub.
+// It returns nil if nothing changed, plus the submodules it could not map.
+func rewriteGitmodules(project Project, inventory map[string]Project, text string) (*fileEdit, []missingSubmodule) {
+	missing := []missingSubmodule{}
+	changed := 0
+	name := ""
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if m := submoduleHeader.FindStringSubmatch(strings.TrimRight(line, "\r\n")); m != nil {
+			name = m[1]
+			continue
+		}
+		body := strings.TrimRight(line, "\r\n")
+		m := submoduleURL.FindStringSubmatch(body)
+		if m == nil {
+			continue
+		}
+		mapped, relevant, found := mapSubmoduleURL(project, inventory, m[2])
+		if relevant && !found {
+			missing = append(missing, missingSubmodule{Name: name, URL: m[2]})
+			continue
+		}
+		if mapped != m[2] {
+			lines[i] = m[1] + mapped + m[3] + line[len(body):]
+			changed++
+		}
+	}
+	if changed == 0 {
+		return nil, missing
+	}
+	return &fileEdit{name: ".gitmodules", before: text, after: strings.Join(lines, ""), matches: map[string]int{"submodules": changed}}, missing
+}
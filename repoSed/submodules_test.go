@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func submoduleInventory() (web Project, inventory map[string]Project) {
+	web = Project{Name: "web", PathWithNamespace: "randall-dev/web"}
+	inventory = projectInventory([]Project{
+		web,
+		{Name: "catalog", PathWithNamespace: "randall-dev/catalog"},
+		{Name: "shared-lib", PathWithNamespace: "randall-dev/Shared-Lib"},
+		{Name: "maps", PathWithNamespace: "special-collections/maps"},
+	})
+	return web, inventory
+}
+
+func TestRewriteGitmodulesURLs(t *testing.T) {
+	web, inventory := submoduleInventory()
+	for _, tc := range []struct {
+		url, want string
+		missing   bool
+	}{
+		{"https://libapps-admin.uncw.edu/randall-dev/catalog.git", "https://github.com/uncw-library/catalog.git", false},
+		{"https://libapps-admin.uncw.edu:8000/randall-dev/catalog", "https://github.com/uncw-library/catalog.git", false},
+		{"git@libapps-admin.uncw.edu:randall-dev/catalog.git", "https://github.com/uncw-library/catalog.git", false},
+		// paths are matched case-insensitively, and the GitHub repo takes the project's name
+		{"https://libapps-admin.uncw.edu/randall-dev/shared-lib.git", "https://github.com/uncw-library/shared-lib.git", false},
+		// relative URLs resolve against the superproject and stay relative
+		{"../catalog.git", "../catalog.git", false},
+		{"./../catalog", "../catalog.git", false},
+		{"../../special-collections/maps.git", "../maps.git", false},
+		{"https://libapps-admin.uncw.edu/randall-dev/gone.git", "https://libapps-admin.uncw.edu/randall-dev/gone.git", true},
+		{"../gone.git", "../gone.git", true},
+		{"https://github.com/other/tool.git", "https://github.com/other/tool.git", false},
+		{"https://gitlab.example.com/randall-dev/catalog.git", "https://gitlab.example.com/randall-dev/catalog.git", false},
+	} {
+		text := "[submodule \"sub\"]\n\tpath = sub\n\turl = " + tc.url + "\n"
+		edit, missing := rewriteGitmodules(web, inventory, text)
+		want := "[submodule \"sub\"]\n\tpath = sub\n\turl = " + tc.want + "\n"
+		switch {
+		case tc.url == tc.want && edit != nil:
+			t.Errorf("%s: rewrote unchanged URL to %q", tc.url, edit.after)
+		case tc.url != tc.want && (edit == nil || edit.after != want || edit.before != text):
+			t.Errorf("%s: edit %+v, want %q", tc.url, edit, want)
+		}
+		wantMissing := []missingSubmodule{}
+		if tc.missing {
+			wantMissing = append(wantMissing, missingSubmodule{Name: "sub", URL: tc.url})
+		}
+		if !slices.Equal(missing, wantMissing) {
+			t.Errorf("%s: missing %+v, want %+v", tc.url, missing, wantMissing)
+		}
+	}
+}
+
+func TestRewriteGitmodulesFile(t *testing.T) {
+	web, inventory := submoduleInventory()
+	text := "[submodule \"catalog\"]\r\n" +
+		"\tpath = catalog\r\n" +
+		"\turl = https://libapps-admin.uncw.edu/randall-dev/catalog.git\r\n" +
+		"[submodule \"gone\"]\n" +
+		"\turl = git@libapps-admin.uncw.edu:randall-dev/gone.git\n" +
+		"[submodule \"maps\"]\n" +
+		"  url   =   ../../special-collections/maps.git  \n" +
+		"[submodule \"tool\"]\n" +
+		"\turl = https://github.com/other/tool.git"
+	want := "[submodule \"catalog\"]\r\n" +
+		"\tpath = catalog\r\n" +
+		"\turl = https://github.com/uncw-library/catalog.git\r\n" +
+		"[submodule \"gone\"]\n" +
+		"\turl = git@libapps-admin.uncw.edu:randall-dev/gone.git\n" +
+		"[submodule \"maps\"]\n" +
+		"  url   =   ../maps.git  \n" +
+		"[submodule \"tool\"]\n" +
+		"\turl = https://github.com/other/tool.git"
+	edit, missing := rewriteGitmodules(web, inventory, text)
+	if edit == nil || edit.after != want || edit.matches["submodules"] != 2 || edit.name != ".gitmodules" {
+		t.Fatalf("edit %+v", edit)
+	}
+	wantMissing := []missingSubmodule{{Name: "gone", URL: "git@libapps-admin.uncw.edu:randall-dev/gone.git"}}
+	if !slices.Equal(missing, wantMissing) {
+		t.Errorf("missing %+v, want %+v", missing, wantMissing)
+	}
+
+	// nothing to map still reports what is missing
+	edit, missing = rewriteGitmodules(web, inventory, "[submodule \"gone\"]\n\turl = ../gone.git\n")
+	if edit != nil || len(missing) != 1 || missing[0].Name != "gone" {
+		t.Errorf("edit %+v, missing %+v", edit, missing)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF32LE = []byte{0xFF, 0xFE, 0x00, 0x00}
+	bomUTF32BE = []byte{0x00, 0x00, 0xFE, 0xFF}
+)
+
+// binarySniffLen is how much of a file is checked for NUL bytes, the same heuristic git uses.
+const binarySniffLen = 8000
+
+// textFormat is what decodeText strips from a file before the rules run, so encode can put it back byte for byte.
+type textFormat struct {
+	bom   []byte
+	utf16 binary.ByteOrder
+	crlf  bool
+}
+
+// decodeText returns the file as UTF-8 text with LF line endings, or the reason it should not be edited.
+// CRLF is only normalized when every line ends that way; mixed endings are left exactly as they are.
+func decodeText(data []byte) (text string, format textFormat, reason string) {
+	body := data
+	switch {
+	case bytes.HasPrefix(data, bomUTF32LE), bytes.HasPrefix(data, bomUTF32BE):
+		return "", format, "UTF-32 encoded"
+	case bytes.HasPrefix(data, bomUTF8):
+		format.bom, body = bomUTF8, data[len(bomUTF8):]
+	case bytes.HasPrefix(data, bomUTF16LE):
+		format.bom, format.utf16, body = bomUTF16LE, binary.LittleEndian, data[len(bomUTF16LE):]
+	case bytes.HasPrefix(data, bomUTF16BE):
+		format.bom, format.utf16, body = bomUTF16BE, binary.BigEndian, data[len(bomUTF16BE):]
+	}
+
+	if format.utf16 != nil {
+		if len(body)%2 != 0 {
+			return "", format, "truncated UTF-16"
+		}
+		units := make([]uint16, len(body)/2)
+		for i := range units {
+			units[i] = format.utf16.Uint16(body[2*i:])
+		}
+		text = string(utf16.Decode(units))
+	} else {
+		if bytes.IndexByte(body[:min(len(body), binarySniffLen)], 0) >= 0 {
+			return "", format, "binary content"
+		}
+		text = string(body)
+	}
+
+	if n := strings.Count(text, "\r\n"); n > 0 && n == strings.Count(text, "\n") {
+		format.crlf = true
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	// anything that would not survive the trip back, such as unpaired UTF-16 surrogates, is left alone
+	if !bytes.Equal(format.encode(text), data) {
+		return "", format, "encoding does not round-trip"
+	}
+	return text, format, ""
+}
+
+// lineEndings restores the file's line endings to text.
+func (f textFormat) lineEndings(text string) string {
+	if f.crlf {
+		return strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text
+}
+
+// encode turns text back into the file's bytes: line endings, encoding and BOM.
+func (f textFormat) encode(text string) []byte {
+	text = f.lineEndings(text)
+	out := append([]byte{}, f.bom...)
+	if f.utf16 == nil {
+		return append(out, text...)
+	}
+	unit := make([]byte, 2)
+	for _, u := range utf16.Encode([]rune(text)) {
+		f.utf16.PutUint16(unit, u)
+		out = append(out, unit...)
+	}
+	return out
+}
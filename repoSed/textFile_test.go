@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func utf16Bytes(order binary.AppendByteOrder, bom []byte, text string) []byte {
+	out := append([]byte{}, bom...)
+	for _, u := range utf16.Encode([]rune(text)) {
+		out = order.AppendUint16(out, u)
+	}
+	return out
+}
+
+func hostRules(t *testing.T) []rule {
+	t.Helper()
+	rules, _, err := loadRules(writeRules(t, "rules:\n  - name: host\n    files: [\"**\"]\n    needle: old-host\n    replacement: new-host\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestRewriteContentRoundTrip(t *testing.T) {
+	plain := func(text string) []byte { return []byte(text) }
+	for _, tc := range []struct {
+		name   string
+		encode func(string) []byte
+		text   string
+	}{
+		{"LF", plain, "url: old-host\nname: x\n"},
+		{"no trailing newline", plain, "url: old-host\nname: x"},
+		{"CRLF", plain, "url: old-host\r\nname: x\r\n"},
+		{"CRLF without trailing newline", plain, "url: old-host\r\nname: x"},
+		{"mixed line endings", plain, "url: old-host\r\nname: x\n"},
+		{"Latin-1", plain, "url: old-host\nnam\xe9: x\n"},
+		{"UTF-8 BOM", func(s string) []byte { return append(append([]byte{}, bomUTF8...), s...) }, "url: old-host\r\n"},
+		{"UTF-16LE", func(s string) []byte { return utf16Bytes(binary.LittleEndian, bomUTF16LE, s) }, "url: old-host\r\nnamé: ✓\r\n"},
+		{"UTF-16BE", func(s string) []byte { return utf16Bytes(binary.BigEndian, bomUTF16BE, s) }, "url: old-host\nnamé: ✓"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := log.New(io.Discard, "", 0)
+			edit, out, err := rewriteContent(logger, "config.yml", tc.encode(tc.text), hostRules(t))
+			if err != nil || edit == nil {
+				t.Fatalf("edit %v, err %v", edit, err)
+			}
+			want := strings.ReplaceAll(tc.text, "old-host", "new-host")
+			if !bytes.Equal(out, tc.encode(want)) {
+				t.Errorf("wrote %q, want %q", out, tc.encode(want))
+			}
+			// the edit holds the text as it is in the file, for diffs and scans
+			if edit.before != tc.text || edit.after != want || edit.matches["host"] != 1 {
+				t.Errorf("edit %+v", edit)
+			}
+		})
+	}
+}
+
+func TestRewriteContentSkips(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		reason string
+	}{
+		{"binary", []byte("old-host\x00\x01\x02"), "binary content"},
+		{"UTF-32", append(append([]byte{}, bomUTF32LE...), 'o', 0, 0, 0), "UTF-32 encoded"},
+		{"truncated UTF-16", append(utf16Bytes(binary.LittleEndian, bomUTF16LE, "old-host"), 'x'), "truncated UTF-16"},
+		{"unpaired surrogate", binary.LittleEndian.AppendUint16(utf16Bytes(binary.LittleEndian, bomUTF16LE, "old-host "), 0xD800), "encoding does not round-trip"},
+		{"LFS pointer", []byte(lfsPointerPrefix + "\noid sha256:old-host\nsize 1\n"), "LFS pointer"},
+	} {
+		_, _, err := rewriteContent(logger, "file", tc.data, hostRules(t))
+		var skip *skipError
+		if !errors.As(err, &skip) || skip.reason != tc.reason {
+			t.Errorf("%s: got %v, want a skip for %q", tc.name, err, tc.reason)
+		}
+	}
+	if edit, out, err := rewriteContent(logger, "file", []byte("nothing to do\n"), hostRules(t)); edit != nil || out != nil || err != nil {
+		t.Errorf("unmatched file gave %v %q %v", edit, out, err)
+	}
+}
+
+func TestEditFile(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	folder := t.TempDir()
+	content := "url: old-host\r\n"
+	if err := os.WriteFile(filepath.Join(folder, "run.sh"), []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := editFile(logger, folder, "run.sh", hostRules(t), int64(len(content)-1), false)
+	var skip *skipError
+	if !errors.As(err, &skip) || skip.reason != "larger than 14 bytes" {
+		t.Errorf("oversized file: got %v", err)
+	}
+	if edit, err := editFile(logger, folder, "missing.sh", hostRules(t), 1<<20, false); edit != nil || err != nil {
+		t.Errorf("missing file: got %v %v", edit, err)
+	}
+
+	edit, err := editFile(logger, folder, "run.sh", hostRules(t), 1<<20, true)
+	if err != nil || edit == nil {
+		t.Fatalf("dry run: edit %v, err %v", edit, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(folder, "run.sh")); string(data) != content {
+		t.Errorf("dry run wrote %q", data)
+	}
+
+	_, err = editFile(logger, folder, "run.sh", hostRules(t), 1<<20, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(folder, "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(folder, "run.sh")); string(data) != "url: new-host\r\n" || info.Mode().Perm() != 0755 {
+		t.Errorf("wrote %q with mode %v", data, info.Mode())
+	}
+}
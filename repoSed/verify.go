@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifyPattern is a legacy reference that should be gone once a project is migrated.
+// Pattern is an extended regexp, as git grep -E reads it.
+type verifyPattern struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// defaultVerifyPatterns are checked when the rules file has no verify section.
+var defaultVerifyPatterns = []verifyPattern{
+	{Name: "registry-host", Pattern: `libapps-admin\.uncw\.edu:8000`},
+	{Name: "gitlab-host", Pattern: `libapps-admin\.uncw\.edu`},
+	{Name: "namespace", Pattern: `randall-dev`},
+}
+
+// loadVerifyPatterns reads the verify section of the rules file.
+func loadVerifyPatterns(filename string) ([]verifyPattern, error) {
+	rf, err := parseRuleFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(rf.Verify) == 0 {
+		return defaultVerifyPatterns, nil
+	}
+	for i, p := range rf.Verify {
+		if p.Name == "" {
+			rf.Verify[i].Name = fmt.Sprintf("verify-%d", i+1)
+		}
+		if p.Pattern == "" {
+			return nil, fmt.Errorf("rules file %s: verify pattern %d is empty", filename, i+1)
+		}
+		// catches most syntax errors before git grep does, once per project
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return nil, fmt.Errorf("rules file %s: verify pattern %q: %v", filename, p.Pattern, err)
+		}
+	}
+	return rf.Verify, nil
+}
+
+// verifyHit is one line that still holds legacy references.
+type verifyHit struct {
+	Project  string   `json:"project"`
+	Branch   string   `json:"branch"`
+	Path     string   `json:"path"`
+	Line     int      `json:"line"`
+	Patterns []string `json:"patterns"`
+	Text     string   `json:"text"`
+}
+
+// excludedPath reports whether name, or a directory above it, is one listFiles would skip.
+func excludedPath(name string, excludes []string) bool {
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		for _, dir := range defaultExcludes {
+			if i < len(segments)-1 && segments[i] == dir {
+				return true
+			}
+		}
+		if matchesAny(excludes, strings.Join(segments[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyFolder fetches the -verify remote and greps each of the project's branches there for the legacy patterns.
+// Nothing is edited; hits are collected in opts.verifies.
+func verifyFolder(logger *log.Logger, folder string, project Project, opts *options) error {
+	logger.Printf("Starting\tverify of %s on %s", project.Name, opts.verifyRemote)
+	err := gitClone(logger, folder, project, opts.git)
+	if err != nil {
+		return err
+	}
+	err = setupRemote(logger, folder, project, opts.verifyRemote, opts)
+	if err != nil {
+		return err
+	}
+	_, err = runCommand(logger, folder, "git", "fetch", "--prune", opts.verifyRemote)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error fetching %s in folder %s", opts.verifyRemote, folder)
+	}
+
+	prefix := fmt.Sprintf("refs/remotes/%s/", opts.verifyRemote)
+	refs := []string{}
+	for _, branch := range project.Branches {
+		if ok, reason := opts.branches.allows(branch, time.Now()); !ok {
+			logger.Printf("Info\tSkipping branch %s: %s", branch.Name, reason)
+			continue
+		}
+		sha, err := opts.git.remoteBranch(logger, folder, opts.verifyRemote, branch.Name)
+		if err != nil {
+			return err
+		}
+		if sha == "" {
+			logger.Printf("Info\tSkipping branch %s: not on %s", branch.Name, opts.verifyRemote)
+			continue
+		}
+		refs = append(refs, prefix+branch.Name)
+	}
+	if len(refs) == 0 {
+		opts.verifies.record(project.Name, nil)
+		return nil
+	}
+
+	hits := map[string]*verifyHit{}
+	for _, pattern := range opts.verifyPatterns {
+		lines, err := gitGrep(logger, folder, pattern.Pattern, refs)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			// -z output is <ref>:<path>\0<line>\0<text>; refs never hold a colon
+			fields := strings.SplitN(line, "\x00", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			ref, name, _ := strings.Cut(fields[0], ":")
+			if excludedPath(name, opts.excludes) {
+				continue
+			}
+			lineNo, _ := strconv.Atoi(fields[1])
+			key := fmt.Sprintf("%s\x00%s\x00%d", ref, name, lineNo)
+			hit, ok := hits[key]
+			if !ok {
+				hit = &verifyHit{Project: project.Name, Branch: strings.TrimPrefix(ref, prefix), Path: name, Line: lineNo, Text: strings.TrimSpace(fields[2])}
+				hits[key] = hit
+			}
+			hit.Patterns = append(hit.Patterns, pattern.Name)
+		}
+	}
+	found := []verifyHit{}
+	for _, hit := range hits {
+		found = append(found, *hit)
+	}
+	logger.Printf("Info\t%d lines with legacy references in %s", len(found), project.Name)
+	opts.verifies.record(project.Name, found)
+	return nil
+}
+
+// gitGrep runs git grep -E for pattern across refs and returns its -z output lines.
+// No match is not an error.
+func gitGrep(logger *log.Logger, folder string, pattern string, refs []string) ([]string, error) {
+	args := append([]string{"grep", "-n", "-I", "-z", "-E", "-e", pattern}, refs...)
+	logger.Printf("Running\tfolder: %v, command: git %v", folder, args)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = folder
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Printf("Error\t%s", stderr.String())
+		return nil, fmt.Errorf("error running git grep for %q in folder %s: %v", pattern, folder, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"), nil
+}
+
+// verifyLog collects every project's hits for the verify report.
+type verifyLog struct {
+	mu       sync.Mutex
+	projects []string
+	hits     []verifyHit
+}
+
+func (v *verifyLog) record(project string, hits []verifyHit) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.projects = append(v.projects, project)
+	v.hits = append(v.hits, hits...)
+}
+
+// write saves the hits as logs/verify-<runID>.json and .csv, prints them per project, and returns how many there are.
+func (v *verifyLog) write(runID string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sort.Slice(v.hits, func(i, j int) bool {
+		a, b := v.hits[i], v.hits[j]
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		if a.Branch != b.Branch {
+			return a.Branch < b.Branch
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Line < b.Line
+	})
+	base := path.Join("logs", fmt.Sprintf("verify-%s", runID))
+
+	jsonFile, err := os.Create(base + ".json")
+	if err != nil {
+		log.Printf("Error\tcreating verify report: %v", err)
+	} else {
+		encoder := json.NewEncoder(jsonFile)
+		encoder.SetIndent("", "  ")
+		hits := v.hits
+		if hits == nil {
+			hits = []verifyHit{}
+		}
+		err = encoder.Encode(hits)
+		jsonFile.Close()
+		if err != nil {
+			log.Printf("Error\twriting verify report: %v", err)
+		}
+	}
+
+	csvFile, err := os.Create(base + ".csv")
+	if err != nil {
+		log.Printf("Error\tcreating verify report: %v", err)
+	} else {
+		w := csv.NewWriter(csvFile)
+		w.Write([]string{"project", "branch", "path", "line", "patterns", "text"})
+		for _, h := range v.hits {
+			w.Write([]string{h.Project, h.Branch, h.Path, strconv.Itoa(h.Line), strings.Join(h.Patterns, " "), h.Text})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Printf("Error\twriting verify report: %v", err)
+		}
+		csvFile.Close()
+	}
+
+	byProject := map[string][]verifyHit{}
+	for _, h := range v.hits {
+		byProject[h.Project] = append(byProject[h.Project], h)
+	}
+	sort.Strings(v.projects)
+	for _, project := range v.projects {
+		hits := byProject[project]
+		if len(hits) == 0 {
+			fmt.Printf("%s: clean\n", project)
+			continue
+		}
+		fmt.Printf("%s: %d lines with legacy references\n", project, len(hits))
+		for _, h := range hits {
+			fmt.Printf("  %s:%s:%d\t%s\t%s\n", h.Branch, h.Path, h.Line, strings.Join(h.Patterns, ","), h.Text)
+		}
+	}
+	log.Printf("Verify\t%d lines with legacy references in %d projects, see %s.json", len(v.hits), len(byProject), base)
+	return len(v.hits)
+}
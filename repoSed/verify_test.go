@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerifyFindsLeftoverReferences(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.addProject("catalog", []string{"main", "feature"}, map[string]map[string]string{
+		"main": {"docker-compose.yml": composeBefore, "node_modules/pkg/index.js": "// libapps-admin.uncw.edu\n"},
+		"feature": {
+			"deploy/run.sh": "#!/bin/sh\ndocker pull libapps-admin.uncw.edu:8000/randall-dev/catalog/web\n",
+		},
+	})
+	f.addProject("plain", []string{"main"}, map[string]map[string]string{
+		"main": {"README.md": "Nothing to migrate here.\n"},
+	})
+	opts := testOptions(t, f, "go")
+	opts.pushRemote = "origin"
+	_, erroreds := doTheWork(opts)
+	if len(erroreds) != 0 {
+		t.Fatalf("rewrite erroreds %v", erroreds)
+	}
+
+	opts.pushRemote = ""
+	opts.verifyRemote = "origin"
+	_, erroreds = doTheWork(opts)
+	if len(erroreds) != 0 {
+		t.Fatalf("verify erroreds %v", erroreds)
+	}
+	want := []verifyHit{{
+		Project:  "catalog",
+		Branch:   "feature",
+		Path:     "deploy/run.sh",
+		Line:     2,
+		Patterns: []string{"registry-host", "gitlab-host", "namespace"},
+		Text:     "docker pull libapps-admin.uncw.edu:8000/randall-dev/catalog/web",
+	}}
+	if left := opts.verifies.write(opts.runID); left != len(want) {
+		t.Errorf("write returned %d, want %d", left, len(want))
+	}
+	if !reflect.DeepEqual(opts.verifies.hits, want) {
+		t.Errorf("hits\n%+v\nwant\n%+v", opts.verifies.hits, want)
+	}
+}
+
+func TestExcludedPath(t *testing.T) {
+	excludes := []string{"docs/**", "*.min.js"}
+	for name, want := range map[string]bool{
+		"README.md":                 false,
+		"node_modules/pkg/index.js": true,
+		"src/vendor/lib.go":         true,
+		"vendor":                    false,
+		"docs/old/setup.md":         true,
+		"app.min.js":                true,
+		"src/app.min.js":            false,
+	} {
+		if got := excludedPath(name, excludes); got != want {
+			t.Errorf("excludedPath(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
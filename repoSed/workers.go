@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// projectResult is the outcome of one project's run.
+type projectResult struct {
+	folder string
+	err    error
+}
+
+// runProjects processes projects with at most opts.concurrency running at once.
+// A project's branches are still done one after another, since they share one working directory.
+// Results are returned in the same order as projects.
+func runProjects(projects []Project, opts *options) []projectResult {
+	results := make([]projectResult, len(projects))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runProject(projects[i], opts)
+			}
+		}()
+	}
+	for i := range projects {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// projectKey names a project's clone and log files after its path_with_namespace, with "/" as "__",
+// so projects with the same name in different namespaces never share them.
+func projectKey(project Project) string {
+	if project.PathWithNamespace == "" {
+		return project.Name
+	}
+	return strings.ReplaceAll(project.PathWithNamespace, "/", "__")
+}
+
+// runProject does one project, logging to its own file so concurrent projects don't interleave.
+func runProject(project Project, opts *options) projectResult {
+	dest := filepath.Join(opts.targetDir, projectKey(project))
+	if !opts.journal.pending(project, opts.retryFailed) {
+		log.Printf("Skipping\t%s, journal has nothing left to do", project.Name)
+		opts.report.recordProject(project, dest, journalSkipped, "journal has nothing left to do")
+		return projectResult{folder: dest}
+	}
+	logpath := filepath.Join(opts.projectLogDir, fmt.Sprintf("%s.log", projectKey(project)))
+	logFile, err := os.OpenFile(logpath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error\t%v", err)
+		err = fmt.Errorf("error opening project log %s: %v", logpath, err)
+		opts.report.recordProject(project, dest, "error", err.Error())
+		return projectResult{folder: dest, err: err}
+	}
+	defer logFile.Close()
+	logger := log.New(logFile, "", log.LstdFlags|log.Lshortfile)
+
+	log.Printf("Starting\t%s, logging to %s", project.Name, logpath)
+	work := doFolder
+	if opts.bare {
+		work = bareFolder
+	}
+	if opts.rollback {
+		work = rollbackFolder
+	}
+	if opts.rewriteHistory {
+		work = historyFolder
+	}
+	if opts.verifyRemote != "" {
+		work = verifyFolder
+	}
+	err = work(logger, dest, project, opts)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		log.Printf("Error\t%s\t%v", project.Name, err)
+		opts.report.recordProject(project, dest, "error", err.Error())
+		return projectResult{folder: dest, err: err}
+	}
+	log.Printf("Finished\t%s", project.Name)
+	opts.report.recordProject(project, dest, "success", "")
+	return projectResult{folder: dest}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestProjectKey(t *testing.T) {
+	library := projectKey(Project{Name: "web", PathWithNamespace: "randall-dev/web"})
+	archives := projectKey(Project{Name: "web", PathWithNamespace: "archives/web"})
+	if library == archives {
+		t.Errorf("projects named web in two namespaces share %q", library)
+	}
+	if library != "randall-dev__web" {
+		t.Errorf("projectKey = %q", library)
+	}
+	if got := projectKey(Project{Name: "web"}); got != "web" {
+		t.Errorf("projectKey without a path = %q", got)
+	}
+}
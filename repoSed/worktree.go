@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// worktreePath is where a branch gets checked out: beside the project's clone, one directory per branch.
+// Every branch's worktree exists at once, so names the slug changes get a hash of the name to keep them apart.
+func worktreePath(folder string, branch Branch) string {
+	dir := slug(branch.Name)
+	if dir != branch.Name {
+		sum := sha256.Sum256([]byte(branch.Name))
+		dir += "-" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(folder+".worktrees", dir)
+}
+
+// addWorktree checks the branch out into a fresh worktree of its own and returns its path.
+// A worktree left behind by an interrupted run is removed first, so nothing carries over between branches.
+func addWorktree(logger *log.Logger, folder string, branch Branch) (string, error) {
+	dir := worktreePath(folder, branch)
+	removeWorktree(logger, folder, dir)
+	logger.Printf("branch is: %v", branch)
+
+	args := []string{"worktree", "add", dir, branch.Name}
+	_, err := runCommand(logger, folder, "git", "rev-parse", "--verify", "--quiet", "refs/heads/"+branch.Name)
+	if err != nil {
+		// no local branch yet, so start one from GitLab's
+		args = []string{"worktree", "add", "--track", "-b", branch.Name, dir, "origin/" + branch.Name}
+	}
+	_, err = runCommand(logger, folder, "git", args...)
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return "", fmt.Errorf("error adding worktree for branch %s in folder %s", branch.Name, folder)
+	}
+	return dir, nil
+}
+
+// removeWorktree deletes a branch's worktree, discarding anything left in it.
+func removeWorktree(logger *log.Logger, folder string, dir string) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return
+	}
+	_, err := runCommand(logger, folder, "git", "worktree", "remove", "--force", dir)
+	if err != nil {
+		// worktrees with submodules refuse to be removed; delete them and let prune tidy up
+		os.RemoveAll(dir)
+	}
+	runCommand(logger, folder, "git", "worktree", "prune")
+	// drop the parent directory once the last worktree is gone
+	os.Remove(filepath.Dir(dir))
+}
+
+// detachHead frees the clone's current branch so a worktree can check it out.
+func detachHead(logger *log.Logger, folder string) error {
+	_, err := runCommand(logger, folder, "git", "checkout", "--detach")
+	if err != nil {
+		logger.Printf("Error\t%v", err)
+		return fmt.Errorf("error detaching HEAD in folder %s", folder)
+	}
+	return nil
+}
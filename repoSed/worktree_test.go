@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWorktreePath(t *testing.T) {
+	seen := map[string]string{}
+	for _, name := range []string{"main", "feature-x", "feature/x", "Feature-X", "release/1.2"} {
+		dir := worktreePath("repos/catalog", Branch{Name: name})
+		if filepath.Dir(dir) != "repos/catalog.worktrees" {
+			t.Errorf("%s: worktree %s is not beside the clone", name, dir)
+		}
+		if other, ok := seen[dir]; ok {
+			t.Errorf("%s and %s share the worktree %s", name, other, dir)
+		}
+		seen[dir] = name
+	}
+	if dir := worktreePath("repos/catalog", Branch{Name: "main"}); dir != "repos/catalog.worktrees/main" {
+		t.Errorf("main's worktree is %s", dir)
+	}
+}